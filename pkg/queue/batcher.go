@@ -0,0 +1,201 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"sync"
+	"time"
+)
+
+// Batcher collects pushed elements into batches and hands every batch off to a flush
+// function once it holds maxCount elements, once the total cost of its elements reaches
+// the limit set with WithMaxBytes, or once the linger time has passed since the first
+// element of the batch was pushed, whichever comes first.
+//
+// Batches are flushed one at a time, in the order their elements were pushed, and Push
+// blocks while a batch is being flushed. It is thread safe.
+type Batcher[T any, P Pointer[T]] struct {
+	batch      []P
+	bytes      uint64
+	generation uint64
+	closed     bool
+	lock       *sync.Mutex
+	timer      *time.Timer
+	flush      func([]P) error
+	sizer      func(P) uint64
+	maxCount   int
+	maxBytes   uint64
+	linger     time.Duration
+}
+
+// NewBatcher creates a new Batcher that passes every batch to the given flush function,
+// which owns the batch once it returns nil. If it returns an error the batch is kept,
+// so it must not hold on to the batch, and the batch is passed to it again, possibly
+// with more elements appended, the next time it is flushed.
+//
+// The flush function is called with the lock of the Batcher held, so it must not call
+// any of the methods of the Batcher.
+//
+// A maxCount or linger of 0 disables flushing by count or by time respectively, and
+// the WithMaxBytes and WithSizer options can be used to also flush by cost.
+func NewBatcher[T any, P Pointer[T]](maxCount int, linger time.Duration, flush func([]P) error, opts ...Option[T, P]) *Batcher[T, P] {
+	b := new(Batcher[T, P])
+	o := newOptions(opts)
+	b.sizer = o.sizeOf()
+	b.maxBytes = o.maxBytes
+	b.maxCount = maxCount
+	b.linger = linger
+	b.flush = flush
+	b.lock = new(sync.Mutex)
+	return b
+}
+
+// FlushTo returns a flush function for a Batcher that pushes every batch to the given
+// queue with PushBatch, so that the queue is only locked once per batch.
+//
+// If the queue only accepts part of a batch, the elements it accepted are not pushed
+// again when the Batcher retries the batch. The returned function keeps track of those
+// elements itself, so it must only be used by a single Batcher.
+//
+// Since the flush function runs with the lock of the Batcher held, every Push blocks
+// while a blocking queue is full, a NonBlocking queue can be used to make Push return
+// FullError instead.
+func FlushTo[T any, P Pointer[T]](q Queue[T, P]) func([]P) error {
+	var pushed int
+	return func(batch []P) error {
+		n, err := q.PushBatch(batch[pushed:])
+		pushed += n
+		if err != nil {
+			return err
+		}
+		pushed = 0
+		return nil
+	}
+}
+
+// Push adds an element to the current batch, flushing the batch if it is full.
+//
+// If flushing fails, the error returned by the flush function is returned, and the
+// element stays part of the batch, which is flushed again by the next call to Push,
+// Flush or Close, or once the linger time has passed. A failed flush triggered by the
+// linger time is never reported by Push, the batch is kept and flushed again once the
+// linger time has passed again, or by the next call to Flush or Close.
+//
+// Once the batch holds twice maxCount elements, or twice the cost set with WithMaxBytes,
+// Push flushes it before adding the element, and returns the error without adding the
+// element if that fails, so that the batch does not grow without bound while the flush
+// function keeps failing.
+func (b *Batcher[T, P]) Push(p P) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.closed {
+		return Closed
+	}
+	if b.isFull(2) {
+		if err := b.flushBatch(); err != nil {
+			return err
+		}
+	}
+	b.batch = append(b.batch, p)
+	b.bytes += b.sizer(p)
+	var err error
+	if b.isFull(1) {
+		err = b.flushBatch()
+	}
+	if len(b.batch) > 0 && b.timer == nil && b.linger > 0 {
+		b.startTimer()
+	}
+	return err
+}
+
+// isFull is an internal function used to check if the current batch holds at least
+// factor times maxCount elements, or factor times the cost set with WithMaxBytes.
+func (b *Batcher[T, P]) isFull(factor int) bool {
+	return (b.maxCount > 0 && len(b.batch) >= factor*b.maxCount) ||
+		(b.maxBytes > 0 && b.bytes >= uint64(factor)*b.maxBytes)
+}
+
+// startTimer is an internal function used to flush the current batch once the
+// linger time has passed, it must be called with the lock held.
+func (b *Batcher[T, P]) startTimer() {
+	generation := b.generation
+	b.timer = time.AfterFunc(b.linger, func() {
+		b.expire(generation)
+	})
+}
+
+// expire is called once the linger time of the batch with the given generation
+// has passed, and flushes it if it was not flushed yet, trying again after
+// another linger time if flushing it fails.
+func (b *Batcher[T, P]) expire(generation uint64) {
+	b.lock.Lock()
+	if b.generation == generation {
+		b.timer = nil
+		if b.flushBatch() != nil {
+			b.startTimer()
+		}
+	}
+	b.lock.Unlock()
+}
+
+// flushBatch is an internal function used to hand the current batch off to the
+// flush function and start a new batch once it succeeds, it must be called with
+// the lock held.
+func (b *Batcher[T, P]) flushBatch() error {
+	if len(b.batch) == 0 {
+		return nil
+	}
+	if err := b.flush(b.batch); err != nil {
+		return err
+	}
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	b.batch = nil
+	b.bytes = 0
+	b.generation++
+	return nil
+}
+
+// Flush hands the current batch off to the flush function, even if it is not
+// full, and returns once the flush function has returned.
+func (b *Batcher[T, P]) Flush() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.flushBatch()
+}
+
+// Length returns the number of elements in the current batch.
+func (b *Batcher[T, P]) Length() (size int) {
+	b.lock.Lock()
+	size = len(b.batch)
+	b.lock.Unlock()
+	return
+}
+
+// IsClosed returns true if the Batcher is closed.
+func (b *Batcher[T, P]) IsClosed() (closed bool) {
+	b.lock.Lock()
+	closed = b.closed
+	b.lock.Unlock()
+	return
+}
+
+// Close closes the Batcher permanently, handing the final partial batch off to the
+// flush function before it returns. All future Push calls return Closed.
+//
+// If flushing the final batch fails, the error is returned and the Batcher is not
+// closed, so that Close can be called again.
+func (b *Batcher[T, P]) Close() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.closed {
+		return nil
+	}
+	if err := b.flushBatch(); err != nil {
+		return err
+	}
+	b.closed = true
+	return nil
+}
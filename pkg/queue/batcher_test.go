@@ -0,0 +1,265 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatcher(t *testing.T) {
+	t.Parallel()
+
+	collector := func() (func([]*P) error, func() [][]*P) {
+		var lock sync.Mutex
+		var batches [][]*P
+		return func(batch []*P) error {
+				lock.Lock()
+				batches = append(batches, batch)
+				lock.Unlock()
+				return nil
+			}, func() [][]*P {
+				lock.Lock()
+				defer lock.Unlock()
+				return append([][]*P(nil), batches...)
+			}
+	}
+
+	t.Run("max count", func(t *testing.T) {
+		flush, batches := collector()
+		b := NewBatcher[P, *P](3, 0, flush)
+		for i := 0; i < 7; i++ {
+			require.NoError(t, b.Push(numbered(i)))
+		}
+		require.Len(t, batches(), 2)
+		assert.Equal(t, []*P{numbered(0), numbered(1), numbered(2)}, batches()[0])
+		assert.Equal(t, []*P{numbered(3), numbered(4), numbered(5)}, batches()[1])
+		assert.Equal(t, 1, b.Length())
+
+		require.NoError(t, b.Flush())
+		require.Len(t, batches(), 3)
+		assert.Equal(t, []*P{numbered(6)}, batches()[2])
+		require.NoError(t, b.Flush())
+		assert.Len(t, batches(), 3)
+	})
+	t.Run("max bytes", func(t *testing.T) {
+		var flushed [][]*frame
+		b := NewBatcher[frame, *frame](0, 0, func(batch []*frame) error {
+			flushed = append(flushed, batch)
+			return nil
+		}, WithMaxBytes[frame, *frame](100))
+		for _, size := range []int{40, 40, 30, 10, 90, 200} {
+			require.NoError(t, b.Push(&frame{data: make([]byte, size)}))
+		}
+		require.Len(t, flushed, 3)
+		assert.Len(t, flushed[0], 3)
+		assert.Len(t, flushed[1], 2)
+		assert.Len(t, flushed[2], 1)
+		assert.Equal(t, 0, b.Length())
+	})
+	t.Run("linger", func(t *testing.T) {
+		flushCh := make(chan []*P, 1)
+		b := NewBatcher[P, *P](10, time.Millisecond*10, func(batch []*P) error {
+			flushCh <- batch
+			return nil
+		})
+		require.NoError(t, b.Push(numbered(0)))
+		require.NoError(t, b.Push(numbered(1)))
+		assert.Equal(t, []*P{numbered(0), numbered(1)}, receive(t, flushCh, "Batcher did not flush after linger time"))
+		assert.Equal(t, 0, b.Length())
+
+		require.NoError(t, b.Push(numbered(2)))
+		require.NoError(t, b.Flush())
+		assert.Equal(t, []*P{numbered(2)}, <-flushCh)
+		select {
+		case <-flushCh:
+			t.Fatal("Batcher flushed an already flushed batch after linger time")
+		case <-time.After(time.Millisecond * 20):
+		}
+	})
+	t.Run("flush error", func(t *testing.T) {
+		flushErr := errors.New("flush failed")
+		var flushed [][]*P
+		fail := true
+		b := NewBatcher[P, *P](2, 0, func(batch []*P) error {
+			if fail {
+				return flushErr
+			}
+			flushed = append(flushed, batch)
+			return nil
+		})
+		require.NoError(t, b.Push(numbered(0)))
+		assert.ErrorIs(t, b.Push(numbered(1)), flushErr)
+		assert.Equal(t, 2, b.Length())
+		assert.ErrorIs(t, b.Flush(), flushErr)
+		assert.ErrorIs(t, b.Push(numbered(2)), flushErr)
+		assert.Equal(t, 3, b.Length())
+
+		fail = false
+		require.NoError(t, b.Flush())
+		assert.Equal(t, [][]*P{{numbered(0), numbered(1), numbered(2)}}, flushed)
+		assert.Equal(t, 0, b.Length())
+	})
+	t.Run("linger error", func(t *testing.T) {
+		flushErr := errors.New("flush failed")
+		flushCh := make(chan []*P, 4)
+		var failures int32 = 2
+		b := NewBatcher[P, *P](10, time.Millisecond*10, func(batch []*P) error {
+			if atomic.AddInt32(&failures, -1) >= 0 {
+				flushCh <- nil
+				return flushErr
+			}
+			flushCh <- batch
+			return nil
+		})
+		require.NoError(t, b.Push(numbered(0)))
+		assert.Nil(t, <-flushCh)
+		require.NoError(t, b.Push(numbered(1)))
+		assert.Nil(t, <-flushCh)
+		assert.Equal(t, []*P{numbered(0), numbered(1)}, receive(t, flushCh, "Batcher did not flush again after a failed flush"))
+		assert.Equal(t, 0, b.Length())
+		assert.NoError(t, b.Flush())
+	})
+	t.Run("linger after flush error", func(t *testing.T) {
+		flushErr := errors.New("flush failed")
+		flushCh := make(chan []*P, 2)
+		var failures int32 = 1
+		b := NewBatcher[P, *P](2, time.Millisecond*10, func(batch []*P) error {
+			if atomic.AddInt32(&failures, -1) >= 0 {
+				return flushErr
+			}
+			flushCh <- batch
+			return nil
+		})
+		require.NoError(t, b.Push(numbered(0)))
+		assert.ErrorIs(t, b.Push(numbered(1)), flushErr)
+		assert.Equal(t, []*P{numbered(0), numbered(1)}, receive(t, flushCh, "Batcher did not flush after a failed flush"))
+		assert.Equal(t, 0, b.Length())
+	})
+	t.Run("flush error limit", func(t *testing.T) {
+		flushErr := errors.New("flush failed")
+		var flushed [][]*P
+		fail := true
+		b := NewBatcher[P, *P](2, 0, func(batch []*P) error {
+			if fail {
+				return flushErr
+			}
+			flushed = append(flushed, batch)
+			return nil
+		})
+		require.NoError(t, b.Push(numbered(0)))
+		for i := 1; i < 6; i++ {
+			assert.ErrorIs(t, b.Push(numbered(i)), flushErr)
+		}
+		assert.Equal(t, 4, b.Length())
+
+		fail = false
+		require.NoError(t, b.Push(numbered(6)))
+		assert.Equal(t, [][]*P{{numbered(0), numbered(1), numbered(2), numbered(3)}}, flushed)
+		assert.Equal(t, 1, b.Length())
+	})
+	t.Run("close", func(t *testing.T) {
+		flush, batches := collector()
+		b := NewBatcher[P, *P](10, time.Hour, flush)
+		require.NoError(t, b.Push(numbered(0)))
+		require.NoError(t, b.Push(numbered(1)))
+		require.NoError(t, b.Close())
+		assert.True(t, b.IsClosed())
+		require.Len(t, batches(), 1)
+		assert.Equal(t, []*P{numbered(0), numbered(1)}, batches()[0])
+		assert.ErrorIs(t, b.Push(numbered(2)), Closed)
+		require.NoError(t, b.Close())
+		assert.Len(t, batches(), 1)
+	})
+	t.Run("close error", func(t *testing.T) {
+		flushErr := errors.New("flush failed")
+		var flushed [][]*P
+		fail := true
+		b := NewBatcher[P, *P](10, 0, func(batch []*P) error {
+			if fail {
+				return flushErr
+			}
+			flushed = append(flushed, batch)
+			return nil
+		})
+		require.NoError(t, b.Push(numbered(0)))
+		assert.ErrorIs(t, b.Close(), flushErr)
+		assert.False(t, b.IsClosed())
+		require.NoError(t, b.Push(numbered(1)))
+
+		fail = false
+		require.NoError(t, b.Close())
+		assert.True(t, b.IsClosed())
+		assert.Equal(t, [][]*P{{numbered(0), numbered(1)}}, flushed)
+	})
+	t.Run("flush to queue", func(t *testing.T) {
+		rb := NewCircular[P, *P](8)
+		b := NewBatcher[P, *P](2, 0, FlushTo[P, *P](rb))
+		for i := 0; i < 5; i++ {
+			require.NoError(t, b.Push(numbered(i)))
+		}
+		assert.Equal(t, 4, rb.Length())
+		require.NoError(t, b.Close())
+		for i := 0; i < 5; i++ {
+			p, err := rb.Pop()
+			require.NoError(t, err)
+			assert.Equal(t, i, p.Int)
+		}
+	})
+	t.Run("flush to full queue", func(t *testing.T) {
+		rb := NewNonBlocking[P, *P](2)
+		b := NewBatcher[P, *P](5, 0, FlushTo[P, *P](rb))
+		for i := 0; i < 4; i++ {
+			require.NoError(t, b.Push(numbered(i)))
+		}
+		assert.ErrorIs(t, b.Push(numbered(4)), FullError)
+		assert.Equal(t, 3, rb.Length())
+		assert.Equal(t, 5, b.Length())
+
+		for i := 0; i < 3; i++ {
+			p, err := rb.Pop()
+			require.NoError(t, err)
+			assert.Equal(t, i, p.Int)
+		}
+		require.NoError(t, b.Flush())
+		for i := 3; i < 5; i++ {
+			p, err := rb.Pop()
+			require.NoError(t, err)
+			assert.Equal(t, i, p.Int)
+		}
+		assert.True(t, rb.IsEmpty())
+	})
+	t.Run("concurrent producers", func(t *testing.T) {
+		const producers = 4
+		const count = 250
+		flush, batches := collector()
+		b := NewBatcher[P, *P](16, time.Millisecond, flush)
+		var wg sync.WaitGroup
+		wg.Add(producers)
+		for i := 0; i < producers; i++ {
+			go func(offset int) {
+				defer wg.Done()
+				for j := 0; j < count; j++ {
+					assert.NoError(t, b.Push(numbered(offset*count+j)))
+				}
+			}(i)
+		}
+		wg.Wait()
+		require.NoError(t, b.Close())
+
+		seen := make(map[int]struct{}, producers*count)
+		for _, batch := range batches() {
+			assert.LessOrEqual(t, len(batch), 16)
+			for _, p := range batch {
+				seen[p.Int] = struct{}{}
+			}
+		}
+		assert.Len(t, seen, producers*count)
+	})
+}
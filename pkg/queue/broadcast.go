@@ -0,0 +1,346 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+// unsubscribed is the cursor of a Subscriber that is no longer
+// subscribed to its Broadcast, which never gates producers.
+const unsubscribed = math.MaxUint64
+
+// Broadcast is a bounded multicast ring buffer where every Subscriber sees every
+// element that is pushed after it subscribed, without the elements being copied
+// into a separate queue per Subscriber.
+//
+// Producers claim a position by advancing the head with a CAS, the same way they do
+// in a LockFree queue, and each Subscriber keeps its own cursor into the ring. A
+// position can only be claimed once every Subscriber has moved past the element that
+// was previously stored in its slot, so producers are gated by the slowest Subscriber.
+//
+// Push operations are safe to use concurrently by multiple producers, and Subscribers
+// can be added and removed at any time. Each Subscriber must only be used by one
+// goroutine at a time.
+type Broadcast[T any, P Pointer[T]] struct {
+	_padding0   [8]uint64 //nolint:structcheck,unused
+	head        uint64
+	_padding1   [8]uint64 //nolint:structcheck,unused
+	mask        uint64
+	_padding2   [8]uint64 //nolint:structcheck,unused
+	closed      uint64
+	_padding3   [8]uint64 //nolint:structcheck,unused
+	nodes       []*node[T, P]
+	_padding4   [8]uint64 //nolint:structcheck,unused
+	subscribers atomic.Value
+	_padding5   [8]uint64 //nolint:structcheck,unused
+	lock        *sync.Mutex
+	_padding6   [8]uint64 //nolint:structcheck,unused
+	wait        WaitStrategy
+	_padding7   [8]uint64 //nolint:structcheck,unused
+	graceful    bool
+}
+
+// Subscriber is a cursor into a Broadcast that receives every element
+// pushed to the Broadcast after it subscribed, in order.
+type Subscriber[T any, P Pointer[T]] struct {
+	_padding0 [8]uint64 //nolint:structcheck,unused
+	cursor    uint64
+	_padding1 [8]uint64 //nolint:structcheck,unused
+	broadcast *Broadcast[T, P]
+}
+
+// NewBroadcast creates a new Broadcast that can hold the given number of elements,
+// rounded up to the nearest power of 2, that have not yet been seen by every Subscriber.
+//
+// By default blocking operations use the Yield WaitStrategy, the WithWaitStrategy
+// option can be used to change it. The WithDrainOnClose option can be used to keep
+// returning the remaining elements to Subscribers after the Broadcast is closed.
+func NewBroadcast[T any, P Pointer[T]](size uint64, opts ...Option[T, P]) *Broadcast[T, P] {
+	q := new(Broadcast[T, P])
+	if size < 1 {
+		size = 1
+	}
+	o := newOptions(opts)
+	q.wait = o.wait
+	q.graceful = o.graceful
+	q.lock = new(sync.Mutex)
+	q.subscribers.Store([]*Subscriber[T, P]{})
+
+	size = round(size)
+	q.nodes = make(nodes[T, P], size)
+	for i := uint64(0); i < size; i++ {
+		q.nodes[i] = new(node[T, P])
+	}
+	q.mask = size - 1
+	return q
+}
+
+// Subscribe adds a new Subscriber to the Broadcast, which will receive
+// every element that is pushed from now on.
+//
+// The Subscriber must be removed with its Unsubscribe method once it is no
+// longer used, otherwise it will eventually block all producers.
+func (q *Broadcast[T, P]) Subscribe() *Subscriber[T, P] {
+	s := q.add()
+	q.start(s, atomic.LoadUint64(&q.head))
+	return s
+}
+
+// add adds a new Subscriber to the Broadcast without a cursor,
+// so that producers do not wait for it yet.
+func (q *Broadcast[T, P]) add() *Subscriber[T, P] {
+	s := &Subscriber[T, P]{
+		cursor:    unsubscribed,
+		broadcast: q,
+	}
+	q.lock.Lock()
+	subscribers := q.subscribers.Load().([]*Subscriber[T, P])
+	updated := make([]*Subscriber[T, P], len(subscribers), len(subscribers)+1)
+	copy(updated, subscribers)
+	q.subscribers.Store(append(updated, s))
+	q.lock.Unlock()
+	return s
+}
+
+// start sets the cursor of a Subscriber returned by add to the given head position,
+// which must have been loaded after the Subscriber was added.
+//
+// Producers that checked the subscribers before the cursor was set ignore it, so they
+// can keep claiming positions while it is being set, and may have lapped the slot of
+// the given position. Once the cursor is visible, such a producer can only still claim
+// the position at the head that is loaded right after, so the cursor is moved forward
+// until the head is within a ring's length of it.
+func (q *Broadcast[T, P]) start(s *Subscriber[T, P], cursor uint64) {
+	atomic.StoreUint64(&s.cursor, cursor)
+	for {
+		head := atomic.LoadUint64(&q.head)
+		if head-cursor <= q.mask || !atomic.CompareAndSwapUint64(&s.cursor, cursor, head) {
+			return
+		}
+		cursor = head
+	}
+}
+
+// unsubscribe removes the given Subscriber from the Broadcast.
+func (q *Broadcast[T, P]) unsubscribe(s *Subscriber[T, P]) {
+	q.lock.Lock()
+	subscribers := q.subscribers.Load().([]*Subscriber[T, P])
+	updated := make([]*Subscriber[T, P], 0, len(subscribers))
+	for _, subscriber := range subscribers {
+		if subscriber != s {
+			updated = append(updated, subscriber)
+		}
+	}
+	q.subscribers.Store(updated)
+	atomic.StoreUint64(&s.cursor, unsubscribed)
+	q.lock.Unlock()
+	q.wait.Notify()
+}
+
+// Subscribers returns the number of Subscribers currently subscribed to the Broadcast.
+func (q *Broadcast[T, P]) Subscribers() int {
+	return len(q.subscribers.Load().([]*Subscriber[T, P]))
+}
+
+// available returns whether every Subscriber has moved past the element
+// stored in the slot for the given head position.
+func (q *Broadcast[T, P]) available(head uint64) bool {
+	for _, s := range q.subscribers.Load().([]*Subscriber[T, P]) {
+		if cursor := atomic.LoadUint64(&s.cursor); cursor != unsubscribed && head-cursor > q.mask {
+			return false
+		}
+	}
+	return true
+}
+
+// tryPush claims the next position if it is available and stores the element in it,
+// returning false if the slowest Subscriber has not yet moved past that position's slot.
+func (q *Broadcast[T, P]) tryPush(p P) bool {
+	for {
+		head := atomic.LoadUint64(&q.head)
+		if !q.available(head) {
+			return false
+		}
+		if atomic.CompareAndSwapUint64(&q.head, head, head+1) {
+			n := q.nodes[head&q.mask]
+			n.store(p)
+			atomic.StoreUint64(&n.position, head+1)
+			q.wait.Notify()
+			return true
+		}
+	}
+}
+
+// TryPush adds an element to the Broadcast without blocking, returning FullError
+// if the slowest Subscriber has not yet seen enough elements to make space for it.
+func (q *Broadcast[T, P]) TryPush(p P) error {
+	if atomic.LoadUint64(&q.closed) == 1 {
+		return Closed
+	}
+	if !q.tryPush(p) {
+		return FullError
+	}
+	return nil
+}
+
+// Push adds an element to the Broadcast, blocking until the slowest
+// Subscriber has seen enough elements to make space for it.
+func (q *Broadcast[T, P]) Push(p P) error {
+	return q.PushContext(context.Background(), p)
+}
+
+// PushContext adds an element to the Broadcast the same way Push does, however if
+// the context is cancelled while waiting for space, ctx.Err() is returned and the
+// element is not added.
+func (q *Broadcast[T, P]) PushContext(ctx context.Context, p P) error {
+	var stop func()
+	defer func() {
+		if stop != nil {
+			stop()
+		}
+	}()
+	var iteration uint64
+	for {
+		epoch := q.wait.Epoch()
+		if atomic.LoadUint64(&q.closed) == 1 {
+			return Closed
+		}
+		if q.tryPush(p) {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if stop == nil {
+			stop = notifyOnDone(ctx, q.wait)
+		}
+		q.wait.Wait(iteration, epoch)
+		iteration++
+	}
+}
+
+// Close marks the Broadcast as closed, returns any waiting Push or Pop calls,
+// and makes all future Push calls return Closed.
+func (q *Broadcast[T, P]) Close() {
+	if atomic.CompareAndSwapUint64(&q.closed, 0, 1) {
+		q.wait.Notify()
+	}
+}
+
+// IsClosed returns whether the Broadcast has been closed
+func (q *Broadcast[T, P]) IsClosed() bool {
+	return atomic.LoadUint64(&q.closed) == 1
+}
+
+// Length returns the number of elements the slowest Subscriber has not seen yet.
+func (q *Broadcast[T, P]) Length() (length int) {
+	for _, s := range q.subscribers.Load().([]*Subscriber[T, P]) {
+		if l := s.Length(); l > length {
+			length = l
+		}
+	}
+	return
+}
+
+// tryPop returns the element at the Subscriber's cursor and advances the
+// cursor if that element has been published, without blocking.
+func (s *Subscriber[T, P]) tryPop() (P, bool, error) {
+	cursor := atomic.LoadUint64(&s.cursor)
+	if cursor == unsubscribed {
+		return nil, false, Closed
+	}
+	n := s.broadcast.nodes[cursor&s.broadcast.mask]
+	if atomic.LoadUint64(&n.position) != cursor+1 {
+		return nil, false, nil
+	}
+	p := n.load()
+	if !atomic.CompareAndSwapUint64(&s.cursor, cursor, cursor+1) {
+		return nil, false, Closed
+	}
+	s.broadcast.wait.Notify()
+	return p, true, nil
+}
+
+// isDone returns whether Pop operations should return Closed, which is as soon as the
+// Broadcast is closed, or once the Subscriber has also seen every element that was
+// pushed if the Broadcast was created with WithDrainOnClose.
+func (s *Subscriber[T, P]) isDone() bool {
+	return atomic.LoadUint64(&s.broadcast.closed) == 1 && (!s.broadcast.graceful || s.Length() == 0)
+}
+
+// TryPop returns the next element without blocking, returning
+// EmptyError if the Subscriber has already seen every element.
+func (s *Subscriber[T, P]) TryPop() (P, error) {
+	if s.isDone() {
+		return nil, Closed
+	}
+	p, ok, err := s.tryPop()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, EmptyError
+	}
+	return p, nil
+}
+
+// Pop returns the next element, blocking until one is pushed.
+func (s *Subscriber[T, P]) Pop() (P, error) {
+	return s.PopContext(context.Background())
+}
+
+// PopContext returns the next element the same way Pop does, however if the
+// context is cancelled while waiting for an element, ctx.Err() is returned.
+func (s *Subscriber[T, P]) PopContext(ctx context.Context) (P, error) {
+	var stop func()
+	defer func() {
+		if stop != nil {
+			stop()
+		}
+	}()
+	var iteration uint64
+	for {
+		epoch := s.broadcast.wait.Epoch()
+		if s.isDone() {
+			return nil, Closed
+		}
+		p, ok, err := s.tryPop()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return p, nil
+		}
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+		if stop == nil {
+			stop = notifyOnDone(ctx, s.broadcast.wait)
+		}
+		s.broadcast.wait.Wait(iteration, epoch)
+		iteration++
+	}
+}
+
+// Length returns the number of elements that were pushed to the
+// Broadcast that the Subscriber has not seen yet.
+//
+// Elements that were claimed by a Push but are not yet visible are included,
+// and an unsubscribed Subscriber always has a length of 0.
+func (s *Subscriber[T, P]) Length() int {
+	cursor := atomic.LoadUint64(&s.cursor)
+	if cursor == unsubscribed {
+		return 0
+	}
+	return int(atomic.LoadUint64(&s.broadcast.head) - cursor)
+}
+
+// Unsubscribe removes the Subscriber from its Broadcast, so that it no longer gates
+// producers. Any further Pop calls on the Subscriber return Closed.
+func (s *Subscriber[T, P]) Unsubscribe() {
+	s.broadcast.unsubscribe(s)
+}
@@ -0,0 +1,213 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBroadcast(t *testing.T) {
+	t.Parallel()
+
+	t.Run("fan out", func(t *testing.T) {
+		rb := NewBroadcast[P, *P](4)
+		s1 := rb.Subscribe()
+		s2 := rb.Subscribe()
+		assert.Equal(t, 2, rb.Subscribers())
+		packets := []*P{numbered(0), numbered(1), numbered(2)}
+		for _, p := range packets {
+			require.NoError(t, rb.Push(p))
+		}
+		assert.Equal(t, 3, rb.Length())
+		for _, s := range []*Subscriber[P, *P]{s1, s2} {
+			for _, p := range packets {
+				actual, err := s.Pop()
+				require.NoError(t, err)
+				assert.Same(t, p, actual)
+			}
+			_, err := s.TryPop()
+			assert.ErrorIs(t, err, EmptyError)
+		}
+		assert.Equal(t, 0, rb.Length())
+	})
+	t.Run("gated by slowest subscriber", func(t *testing.T) {
+		rb := NewBroadcast[P, *P](2)
+		fast := rb.Subscribe()
+		slow := rb.Subscribe()
+		for i := 0; i < 2; i++ {
+			require.NoError(t, rb.TryPush(numbered(i)))
+			_, err := fast.Pop()
+			require.NoError(t, err)
+		}
+		assert.ErrorIs(t, rb.TryPush(numbered(2)), FullError)
+		assert.Equal(t, 0, fast.Length())
+		assert.Equal(t, 2, slow.Length())
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+		defer cancel()
+		assert.ErrorIs(t, rb.PushContext(ctx, numbered(2)), context.DeadlineExceeded)
+
+		actual, err := slow.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, 0, actual.Int)
+		require.NoError(t, rb.TryPush(numbered(2)))
+	})
+	t.Run("subscribe and unsubscribe", func(t *testing.T) {
+		rb := NewBroadcast[P, *P](1)
+		require.NoError(t, rb.Push(numbered(0)))
+
+		s1 := rb.Subscribe()
+		_, err := s1.TryPop()
+		assert.ErrorIs(t, err, EmptyError)
+		require.NoError(t, rb.Push(numbered(1)))
+		assert.ErrorIs(t, rb.TryPush(numbered(2)), FullError)
+
+		doneCh := make(chan error, 1)
+		go func() {
+			doneCh <- rb.Push(numbered(2))
+		}()
+		select {
+		case <-doneCh:
+			t.Fatal("Broadcast did not block on slow subscriber")
+		case <-time.After(time.Millisecond * 10):
+		}
+		s1.Unsubscribe()
+		require.NoError(t, receive(t, doneCh, "Broadcast did not unblock on unsubscribe"))
+		assert.Equal(t, 0, rb.Subscribers())
+		_, err = s1.Pop()
+		assert.ErrorIs(t, err, Closed)
+		assert.Equal(t, 0, s1.Length())
+	})
+	t.Run("close", func(t *testing.T) {
+		rb := NewBroadcast[P, *P](2, WithWaitStrategy[P, *P](NewPark(0)))
+		s := rb.Subscribe()
+		doneCh := make(chan error, 1)
+		go func() {
+			_, err := s.Pop()
+			doneCh <- err
+		}()
+		time.Sleep(time.Millisecond * 10)
+		rb.Close()
+		assert.True(t, rb.IsClosed())
+		assert.ErrorIs(t, receive(t, doneCh, "Broadcast did not unblock on close"), Closed)
+		assert.ErrorIs(t, rb.Push(numbered(0)), Closed)
+	})
+	t.Run("drain on close", func(t *testing.T) {
+		rb := NewBroadcast[P, *P](2, WithDrainOnClose[P, *P]())
+		s := rb.Subscribe()
+		require.NoError(t, rb.Push(numbered(0)))
+		rb.Close()
+		actual, err := s.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, 0, actual.Int)
+		_, err = s.Pop()
+		assert.ErrorIs(t, err, Closed)
+	})
+	t.Run("concurrency", func(t *testing.T) {
+		const producers = 4
+		const count = 1000
+		rb := NewBroadcast[P, *P](16, WithWaitStrategy[P, *P](NewPark(16)), WithDrainOnClose[P, *P]())
+		subscribers := make([]*Subscriber[P, *P], 3)
+		for i := range subscribers {
+			subscribers[i] = rb.Subscribe()
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < producers; i++ {
+			wg.Add(1)
+			go func(producer int) {
+				defer wg.Done()
+				for j := 0; j < count; j++ {
+					assert.NoError(t, rb.Push(numbered(producer*count+j)))
+				}
+			}(i)
+		}
+		go func() {
+			wg.Wait()
+			rb.Close()
+		}()
+
+		results := make([][]int, len(subscribers))
+		var consumers sync.WaitGroup
+		for i, s := range subscribers {
+			consumers.Add(1)
+			go func(i int, s *Subscriber[P, *P]) {
+				defer consumers.Done()
+				for {
+					p, err := s.Pop()
+					if err != nil {
+						assert.ErrorIs(t, err, Closed)
+						return
+					}
+					results[i] = append(results[i], p.Int)
+				}
+			}(i, s)
+		}
+		consumers.Wait()
+
+		for _, result := range results {
+			require.Len(t, result, producers*count)
+			assert.Equal(t, results[0], result)
+			last := make([]int, producers)
+			for i := range last {
+				last[i] = -1
+			}
+			for _, v := range result {
+				producer := v / count
+				assert.Greater(t, v%count, last[producer])
+				last[producer] = v % count
+			}
+		}
+	})
+	t.Run("subscribe while lapped", func(t *testing.T) {
+		rb := NewBroadcast[P, *P](2)
+		s := rb.add()
+		head := atomic.LoadUint64(&rb.head)
+		for i := 0; i < 3; i++ {
+			require.NoError(t, rb.TryPush(numbered(i)))
+		}
+		rb.start(s, head)
+		assert.Equal(t, 0, s.Length())
+		require.NoError(t, rb.TryPush(numbered(3)))
+		actual, err := s.TryPop()
+		require.NoError(t, err)
+		assert.Equal(t, 3, actual.Int)
+	})
+	t.Run("subscribe while saturated", func(t *testing.T) {
+		const producers = 4
+		rb := NewBroadcast[P, *P](2)
+		ctx, cancel := context.WithCancel(context.Background())
+		var wg sync.WaitGroup
+		wg.Add(producers)
+		for i := 0; i < producers; i++ {
+			go func() {
+				defer wg.Done()
+				for ctx.Err() == nil {
+					_ = rb.PushContext(ctx, numbered(0))
+				}
+			}()
+		}
+
+		for i := 0; i < 1000; i++ {
+			s := rb.Subscribe()
+			for j := 0; j < 4; j++ {
+				popCtx, popCancel := context.WithTimeout(context.Background(), time.Second)
+				_, err := s.PopContext(popCtx)
+				popCancel()
+				if !assert.NoError(t, err, "subscriber %d is stuck", i) {
+					break
+				}
+			}
+			s.Unsubscribe()
+		}
+		cancel()
+		wg.Wait()
+	})
+}
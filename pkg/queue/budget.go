@@ -0,0 +1,453 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Sizer is implemented by elements that know their own cost,
+// usually their size in bytes, in a Budget queue.
+type Sizer interface {
+	Size() uint64
+}
+
+// OversizePolicy determines what a Budget queue does when an element
+// is pushed that costs more than the whole budget.
+type OversizePolicy int
+
+const (
+	// OversizeReject rejects the element and returns OversizeError.
+	OversizeReject OversizePolicy = iota
+
+	// OversizeAlone accepts the element once the queue is empty, so it is
+	// the only element in the queue until it is popped.
+	OversizeAlone
+)
+
+// charged is an element of a Budget queue along with the cost it was charged
+// when it was pushed, which is released when it is popped even if the sizer
+// would now return a different cost for it.
+type charged[T any, P Pointer[T]] struct {
+	p    P
+	cost uint64
+}
+
+// Budget is a FIFO queue that is bounded by the total cost of its elements
+// instead of their number, which makes it possible to bound the memory used
+// by elements whose sizes vary widely.
+//
+// It is thread safe, and will block the caller if there is not enough budget
+// left for the element being pushed or if the queue is empty.
+//
+// Blocked pushers are not served in order. Every time budget is released all of
+// them are woken up and the ones whose elements fit go ahead, so a large element,
+// or an oversized one waiting for the queue to be empty with OversizeAlone, can
+// wait indefinitely while smaller elements keep being pushed. PushContext can be
+// used to bound how long a pusher waits.
+type Budget[T any, P Pointer[T]] struct {
+	_padding0  [8]uint64 //nolint:structcheck,unused
+	ring       *ring[charged[T, P]]
+	_padding1  [8]uint64 //nolint:structcheck,unused
+	used       uint64
+	_padding2  [8]uint64 //nolint:structcheck,unused
+	budget     uint64
+	_padding3  [8]uint64 //nolint:structcheck,unused
+	closed     bool
+	_padding4  [8]uint64 //nolint:structcheck,unused
+	lock       *sync.Mutex
+	_padding5  [8]uint64 //nolint:structcheck,unused
+	notEmpty   *sync.Cond
+	_padding6  [8]uint64 //nolint:structcheck,unused
+	notFull    *sync.Cond
+	_padding7  [8]uint64 //nolint:structcheck,unused
+	sizer      func(P) uint64
+	_padding8  [8]uint64 //nolint:structcheck,unused
+	oversize   OversizePolicy
+	_padding9  [8]uint64 //nolint:structcheck,unused
+	graceful   bool
+	_padding10 [8]uint64 //nolint:structcheck,unused
+	stats      *Stats
+}
+
+// NewBudget creates a new Budget queue that can hold elements
+// with a total cost of at most the given budget.
+//
+// The cost of an element is given by its Size method if P implements Sizer,
+// the WithSizer option can be used to supply a different function. Elements
+// that cost more than the whole budget are rejected unless the WithOversizePolicy
+// option is used.
+//
+// By default Pop returns Closed as soon as the queue is closed, the WithDrainOnClose
+// option can be used to keep returning the remaining elements first.
+func NewBudget[T any, P Pointer[T]](budget uint64, opts ...Option[T, P]) *Budget[T, P] {
+	q := new(Budget[T, P])
+	o := newOptions(opts)
+	q.graceful = o.graceful
+	q.stats = o.stats
+	q.oversize = o.oversize
+	q.sizer = o.sizeOf()
+	q.budget = budget
+	q.lock = new(sync.Mutex)
+	q.notFull = sync.NewCond(q.lock)
+	q.notEmpty = sync.NewCond(q.lock)
+	q.ring = newRing[charged[T, P]](1)
+	return q
+}
+
+// IsEmpty returns true if the queue is empty.
+func (q *Budget[T, P]) IsEmpty() (empty bool) {
+	q.lock.Lock()
+	empty = q.isEmpty()
+	q.lock.Unlock()
+	return
+}
+
+// isEmpty is an internal function used to check if the
+// queue is empty.
+func (q *Budget[T, P]) isEmpty() bool {
+	return q.ring.length() == 0
+}
+
+// IsFull returns true if there is no budget left in the queue.
+func (q *Budget[T, P]) IsFull() (full bool) {
+	q.lock.Lock()
+	full = q.isFull()
+	q.lock.Unlock()
+	return
+}
+
+// isFull is an internal function used to check if there
+// is no budget left in the queue.
+func (q *Budget[T, P]) isFull() bool {
+	return q.used >= q.budget
+}
+
+// fits is an internal function used to check if an element
+// with the given cost can be pushed to the queue right now.
+func (q *Budget[T, P]) fits(size uint64) bool {
+	if size > q.budget {
+		return q.isEmpty()
+	}
+	return q.used+size <= q.budget
+}
+
+// IsClosed returns true if the queue is Closed
+//
+// The Drain method can be used to drain the queue after it is closed.
+func (q *Budget[T, P]) IsClosed() (closed bool) {
+	q.lock.Lock()
+	closed = q.closed
+	q.lock.Unlock()
+	return
+}
+
+// isDone is an internal function used to check if Pop operations
+// should return Closed, which is as soon as the queue is closed, or once
+// it is also empty if it was created with WithDrainOnClose.
+func (q *Budget[T, P]) isDone() bool {
+	return q.closed && (!q.graceful || q.isEmpty())
+}
+
+// Length returns the number of elements in the queue.
+func (q *Budget[T, P]) Length() (size int) {
+	q.lock.Lock()
+	size = q.ring.length()
+	q.lock.Unlock()
+	return
+}
+
+// Used returns the total cost of the elements in the queue.
+//
+// It can be larger than the budget while an oversized
+// element accepted by OversizeAlone is in the queue.
+func (q *Budget[T, P]) Used() (used uint64) {
+	q.lock.Lock()
+	used = q.used
+	q.lock.Unlock()
+	return
+}
+
+// Close closes the queue permanently.
+//
+// The Drain method can be used to drain the queue after it is closed.
+func (q *Budget[T, P]) Close() {
+	q.lock.Lock()
+	q.closed = true
+	q.notFull.Broadcast()
+	q.notEmpty.Broadcast()
+	q.lock.Unlock()
+}
+
+// TryPush adds an element to the queue without blocking, returning
+// FullError if there is not enough budget left for it.
+func (q *Budget[T, P]) TryPush(p P) error {
+	size := q.sizer(p)
+	if size > q.budget && q.oversize == OversizeReject {
+		return OversizeError
+	}
+	q.lock.Lock()
+	if q.closed {
+		q.lock.Unlock()
+		return Closed
+	}
+	if !q.fits(size) {
+		q.lock.Unlock()
+		return FullError
+	}
+	q.push(p, size)
+	q.lock.Unlock()
+	return nil
+}
+
+// Push adds an element to the queue, blocking until
+// there is enough budget left for it.
+func (q *Budget[T, P]) Push(p P) error {
+	return q.PushContext(context.Background(), p)
+}
+
+// PushContext adds an element to the queue, blocking until there is enough budget left for it.
+//
+// If the element costs more than the whole budget, OversizeError is returned unless the
+// queue was created with the OversizeAlone policy, in which case it blocks until the
+// queue is empty. If the context is cancelled while waiting, ctx.Err() is returned and
+// the element is not added to the queue.
+func (q *Budget[T, P]) PushContext(ctx context.Context, p P) error {
+	size := q.sizer(p)
+	if size > q.budget && q.oversize == OversizeReject {
+		return OversizeError
+	}
+	var stop func()
+	var waited time.Time
+	q.lock.Lock()
+LOOP:
+	if q.closed {
+		q.lock.Unlock()
+		q.stats.pushWaited(waited)
+		if stop != nil {
+			stop()
+		}
+		return Closed
+	}
+	if !q.fits(size) {
+		if err := ctx.Err(); err != nil {
+			q.lock.Unlock()
+			q.stats.pushWaited(waited)
+			if stop != nil {
+				stop()
+			}
+			return err
+		}
+		if stop == nil {
+			stop = wakeOnDone(ctx, q.notFull)
+		}
+		if waited.IsZero() {
+			waited = q.stats.now()
+		}
+		q.notFull.Wait()
+		goto LOOP
+	}
+
+	q.push(p, size)
+	q.lock.Unlock()
+	q.stats.pushWaited(waited)
+	if stop != nil {
+		stop()
+	}
+	return nil
+}
+
+// push is an internal function used to add an element
+// with the given cost to the queue.
+func (q *Budget[T, P]) push(p P, size uint64) {
+	q.ring.push(charged[T, P]{p: p, cost: size})
+	q.used += size
+	q.stats.push(1)
+	q.notEmpty.Signal()
+}
+
+// PushBatch adds all the given elements to the queue in order, returning the
+// number of elements that were added.
+//
+// As many elements as there is budget for are added in a single critical section,
+// and if the budget is exhausted PushBatch blocks until more budget is available.
+// Elements from a batch that had to wait may be interleaved with elements
+// from other producers. If an element costs more than the whole budget and
+// the OversizeReject policy is used, OversizeError is returned.
+func (q *Budget[T, P]) PushBatch(ps []P) (n int, err error) {
+	if len(ps) == 0 {
+		return 0, nil
+	}
+	var waited time.Time
+	q.lock.Lock()
+LOOP:
+	if q.closed {
+		q.lock.Unlock()
+		q.stats.pushWaited(waited)
+		return n, Closed
+	}
+	size := q.sizer(ps[n])
+	if size > q.budget && q.oversize == OversizeReject {
+		q.lock.Unlock()
+		q.stats.pushWaited(waited)
+		return n, OversizeError
+	}
+	if !q.fits(size) {
+		if waited.IsZero() {
+			waited = q.stats.now()
+		}
+		q.notFull.Wait()
+		goto LOOP
+	}
+
+	for {
+		q.push(ps[n], size)
+		n++
+		if n == len(ps) {
+			break
+		}
+		size = q.sizer(ps[n])
+		if !q.fits(size) {
+			goto LOOP
+		}
+	}
+	q.lock.Unlock()
+	q.stats.pushWaited(waited)
+	return n, nil
+}
+
+// TryPop removes an element from the queue without blocking,
+// returning EmptyError if the queue is empty.
+func (q *Budget[T, P]) TryPop() (p P, err error) {
+	q.lock.Lock()
+	if q.isDone() {
+		q.lock.Unlock()
+		return nil, Closed
+	}
+	if q.isEmpty() {
+		q.lock.Unlock()
+		return nil, EmptyError
+	}
+	p = q.pop()
+	q.lock.Unlock()
+	return
+}
+
+// Pop removes an element from the queue, blocking
+// until one is available.
+func (q *Budget[T, P]) Pop() (P, error) {
+	return q.PopContext(context.Background())
+}
+
+// PopContext removes an element from the queue, blocking until one is available.
+//
+// If the context is cancelled while waiting, ctx.Err() is returned.
+func (q *Budget[T, P]) PopContext(ctx context.Context) (p P, err error) {
+	var stop func()
+	var waited time.Time
+	q.lock.Lock()
+LOOP:
+	if q.isDone() {
+		q.lock.Unlock()
+		q.stats.popWaited(waited)
+		if stop != nil {
+			stop()
+		}
+		return nil, Closed
+	}
+	if q.isEmpty() {
+		if err = ctx.Err(); err != nil {
+			q.lock.Unlock()
+			q.stats.popWaited(waited)
+			if stop != nil {
+				stop()
+			}
+			return nil, err
+		}
+		if stop == nil {
+			stop = wakeOnDone(ctx, q.notEmpty)
+		}
+		if waited.IsZero() {
+			waited = q.stats.now()
+		}
+		q.notEmpty.Wait()
+		goto LOOP
+	}
+
+	p = q.pop()
+	q.lock.Unlock()
+	q.stats.popWaited(waited)
+	if stop != nil {
+		stop()
+	}
+	return
+}
+
+// PopBatch removes up to max elements from the queue and stores them in dst,
+// returning the number of elements that were removed.
+//
+// It blocks until at least one element is available, and then removes as many
+// elements as are available (up to max and len(dst)) in a single critical section.
+func (q *Budget[T, P]) PopBatch(dst []P, max int) (n int, err error) {
+	if max > len(dst) {
+		max = len(dst)
+	}
+	if max <= 0 {
+		return 0, nil
+	}
+	var waited time.Time
+	q.lock.Lock()
+LOOP:
+	if q.isDone() {
+		q.lock.Unlock()
+		q.stats.popWaited(waited)
+		return 0, Closed
+	}
+	if q.isEmpty() {
+		if waited.IsZero() {
+			waited = q.stats.now()
+		}
+		q.notEmpty.Wait()
+		goto LOOP
+	}
+
+	for ; n < max && !q.isEmpty(); n++ {
+		dst[n] = q.pop()
+	}
+	q.lock.Unlock()
+	q.stats.popWaited(waited)
+	return
+}
+
+// pop is an internal function used to remove the oldest element
+// from the queue, the queue must not be empty.
+//
+// Every waiting Push is woken up since the released budget may be enough
+// for several small elements, or for none of the elements being pushed.
+func (q *Budget[T, P]) pop() P {
+	e := q.ring.pop()
+	q.used -= e.cost
+	q.stats.pop(1)
+	q.notFull.Broadcast()
+	return e.p
+}
+
+// Drain removes all elements from the queue
+// and returns them in a slice.
+//
+// This function should only be called after the queue is closed.
+func (q *Budget[T, P]) Drain() (values []P) {
+	q.lock.Lock()
+	if q.isEmpty() {
+		q.lock.Unlock()
+		return nil
+	}
+	values = make([]P, 0, q.ring.length())
+	for !q.isEmpty() {
+		values = append(values, q.pop())
+	}
+	q.lock.Unlock()
+	return values
+}
@@ -0,0 +1,183 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type frame struct {
+	data []byte
+}
+
+func (f *frame) Size() uint64 {
+	return uint64(len(f.data))
+}
+
+func TestBudget(t *testing.T) {
+	t.Parallel()
+
+	testFrame := func(size int) *frame {
+		return &frame{data: make([]byte, size)}
+	}
+
+	t.Run("sizer interface", func(t *testing.T) {
+		rb := NewBudget[frame, *frame](100)
+		require.NoError(t, rb.Push(testFrame(60)))
+		require.NoError(t, rb.Push(testFrame(40)))
+		assert.Equal(t, uint64(100), rb.Used())
+		assert.True(t, rb.IsFull())
+		assert.ErrorIs(t, rb.TryPush(testFrame(1)), FullError)
+
+		p, err := rb.Pop()
+		require.NoError(t, err)
+		assert.Len(t, p.data, 60)
+		assert.Equal(t, uint64(40), rb.Used())
+		require.NoError(t, rb.TryPush(testFrame(50)))
+		assert.ErrorIs(t, rb.TryPush(testFrame(11)), FullError)
+		assert.Equal(t, 2, rb.Length())
+	})
+	t.Run("sizer option", func(t *testing.T) {
+		rb := NewBudget[P, *P](10, WithSizer[P, *P](func(p *P) uint64 {
+			return uint64(p.Int)
+		}))
+		require.NoError(t, rb.Push(&P{Int: 4}))
+		require.NoError(t, rb.Push(&P{Int: 6}))
+		assert.ErrorIs(t, rb.TryPush(&P{Int: 1}), FullError)
+
+		rb = NewBudget[P, *P](2)
+		require.NoError(t, rb.Push(&P{Int: 100}))
+		require.NoError(t, rb.Push(&P{Int: 100}))
+		assert.True(t, rb.IsFull())
+	})
+	t.Run("release charged cost", func(t *testing.T) {
+		rb := NewBudget[frame, *frame](100)
+		f := testFrame(60)
+		require.NoError(t, rb.Push(f))
+		f.data = f.data[:10]
+		_, err := rb.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, uint64(0), rb.Used())
+		require.NoError(t, rb.TryPush(testFrame(100)))
+	})
+	t.Run("push blocks until budget", func(t *testing.T) {
+		rb := NewBudget[frame, *frame](100)
+		require.NoError(t, rb.Push(testFrame(80)))
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+		defer cancel()
+		assert.ErrorIs(t, rb.PushContext(ctx, testFrame(30)), context.DeadlineExceeded)
+
+		doneCh := make(chan error, 2)
+		go func() {
+			doneCh <- rb.Push(testFrame(30))
+		}()
+		go func() {
+			doneCh <- rb.Push(testFrame(40))
+		}()
+		select {
+		case <-doneCh:
+			t.Fatal("Budget did not block on exhausted budget")
+		case <-time.After(time.Millisecond * 10):
+		}
+
+		_, err := rb.Pop()
+		require.NoError(t, err)
+		for i := 0; i < 2; i++ {
+			select {
+			case err = <-doneCh:
+				require.NoError(t, err)
+			case <-time.After(time.Second):
+				t.Fatal("Budget did not unblock both pushes on pop")
+			}
+		}
+		assert.Equal(t, uint64(70), rb.Used())
+	})
+	t.Run("oversize reject", func(t *testing.T) {
+		rb := NewBudget[frame, *frame](100)
+		assert.ErrorIs(t, rb.Push(testFrame(101)), OversizeError)
+		assert.ErrorIs(t, rb.TryPush(testFrame(101)), OversizeError)
+		n, err := rb.PushBatch([]*frame{testFrame(10), testFrame(101), testFrame(10)})
+		assert.ErrorIs(t, err, OversizeError)
+		assert.Equal(t, 1, n)
+		assert.Equal(t, 1, rb.Length())
+	})
+	t.Run("oversize alone", func(t *testing.T) {
+		rb := NewBudget[frame, *frame](100, WithOversizePolicy[frame, *frame](OversizeAlone))
+		require.NoError(t, rb.Push(testFrame(10)))
+		assert.ErrorIs(t, rb.TryPush(testFrame(200)), FullError)
+
+		doneCh := make(chan error, 1)
+		go func() {
+			doneCh <- rb.Push(testFrame(200))
+		}()
+		select {
+		case <-doneCh:
+			t.Fatal("Budget did not block oversized element on non-empty queue")
+		case <-time.After(time.Millisecond * 10):
+		}
+		_, err := rb.Pop()
+		require.NoError(t, err)
+		select {
+		case err = <-doneCh:
+			require.NoError(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("Budget did not admit oversized element on empty queue")
+		}
+		assert.Equal(t, uint64(200), rb.Used())
+		assert.ErrorIs(t, rb.TryPush(testFrame(1)), FullError)
+
+		p, err := rb.Pop()
+		require.NoError(t, err)
+		assert.Len(t, p.data, 200)
+		assert.Equal(t, uint64(0), rb.Used())
+	})
+	t.Run("batch", func(t *testing.T) {
+		rb := NewBudget[frame, *frame](100)
+		frames := []*frame{testFrame(50), testFrame(50), testFrame(50)}
+		doneCh := make(chan int, 1)
+		go func() {
+			n, err := rb.PushBatch(frames)
+			assert.NoError(t, err)
+			doneCh <- n
+		}()
+		dst := make([]*frame, 3)
+		received := 0
+		for received < 3 {
+			n, err := rb.PopBatch(dst[received:], 3)
+			require.NoError(t, err)
+			received += n
+		}
+		assert.Equal(t, 3, <-doneCh)
+		assert.Equal(t, frames, dst)
+		assert.Equal(t, uint64(0), rb.Used())
+	})
+	t.Run("close", func(t *testing.T) {
+		rb := NewBudget[frame, *frame](100)
+		require.NoError(t, rb.Push(testFrame(100)))
+
+		doneCh := make(chan error, 1)
+		go func() {
+			doneCh <- rb.Push(testFrame(1))
+		}()
+		time.Sleep(time.Millisecond * 10)
+		rb.Close()
+		select {
+		case err := <-doneCh:
+			assert.ErrorIs(t, err, Closed)
+		case <-time.After(time.Second):
+			t.Fatal("Budget did not unblock push on close")
+		}
+		_, err := rb.Pop()
+		assert.ErrorIs(t, err, Closed)
+		values := rb.Drain()
+		require.Len(t, values, 1)
+		assert.Len(t, values[0].data, 100)
+		assert.Equal(t, uint64(0), rb.Used())
+	})
+}
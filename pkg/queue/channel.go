@@ -0,0 +1,131 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"sync"
+)
+
+// Channel adapts a BlockingQueue (such as a Circular or a LockFree) so that
+// it can be used with Go channels and select statements.
+//
+// Elements popped from the queue are delivered on the channel returned by Receive,
+// and elements sent on the channel returned by Send are pushed to the queue. Each
+// direction is served by a single goroutine, and the one serving Send keeps running
+// even after the queue is closed, so Close must always be called to stop it.
+type Channel[T any, P Pointer[T]] struct {
+	queue     BlockingQueue[T, P]
+	receive   chan P
+	send      chan P
+	done      chan struct{}
+	wg        sync.WaitGroup
+	lock      sync.Mutex
+	closeOnce sync.Once
+	received  []P
+	sent      []P
+}
+
+// NewChannel creates a new Channel for the given queue and starts
+// the goroutines that move elements between the queue and the channels.
+func NewChannel[T any, P Pointer[T]](queue BlockingQueue[T, P]) *Channel[T, P] {
+	c := &Channel[T, P]{
+		queue:   queue,
+		receive: make(chan P),
+		send:    make(chan P),
+		done:    make(chan struct{}),
+	}
+	c.wg.Add(2)
+	go c.pumpReceive()
+	go c.pumpSend()
+	return c
+}
+
+// Receive returns a channel on which the elements popped from the queue are delivered.
+//
+// The channel is closed once the queue is closed (and empty, if it was created with
+// WithDrainOnClose), or once the Channel is closed.
+func (c *Channel[T, P]) Receive() <-chan P {
+	return c.receive
+}
+
+// Send returns a channel whose elements are pushed to the queue.
+//
+// The channel is never closed by the Channel, and elements must not be sent
+// on it after the Channel is closed since nothing will receive them. Elements
+// sent after the queue itself was closed are still received, and returned by Close.
+func (c *Channel[T, P]) Send() chan<- P {
+	return c.send
+}
+
+// Close closes the queue, waits for the goroutines of the Channel to exit and
+// returns any elements that were not delivered, in FIFO order.
+//
+// The returned elements include the element that was popped from the queue but not
+// yet received, the elements that were still in the queue, and the elements that were
+// sent but could not be pushed because the queue was closed. Subsequent calls return nil.
+func (c *Channel[T, P]) Close() (values []P) {
+	c.closeOnce.Do(func() {
+		c.queue.Close()
+		close(c.done)
+		c.wg.Wait()
+		c.lock.Lock()
+		values = append(values, c.received...)
+		values = append(values, c.queue.Drain()...)
+		values = append(values, c.sent...)
+		c.received = nil
+		c.sent = nil
+		c.lock.Unlock()
+	})
+	return
+}
+
+// pumpReceive pops elements from the queue and delivers them on the receive channel
+// until the queue or the Channel is closed.
+func (c *Channel[T, P]) pumpReceive() {
+	defer c.wg.Done()
+	defer close(c.receive)
+	for {
+		p, err := c.queue.Pop()
+		if err != nil {
+			return
+		}
+		select {
+		case c.receive <- p:
+		case <-c.done:
+			c.lock.Lock()
+			c.received = append(c.received, p)
+			c.lock.Unlock()
+			return
+		}
+	}
+}
+
+// pumpSend pushes the elements from the send channel to the queue until the send
+// channel or the Channel is closed.
+//
+// Once a Push fails because the queue was closed, which may happen without going
+// through the Channel, the elements that are still sent are no longer pushed but kept
+// so that Close can return them, and senders are never blocked.
+func (c *Channel[T, P]) pumpSend() {
+	defer c.wg.Done()
+	closed := false
+	for {
+		select {
+		case p, ok := <-c.send:
+			if !ok {
+				return
+			}
+			if !closed {
+				if err := c.queue.Push(p); err == nil {
+					continue
+				}
+				closed = true
+			}
+			c.lock.Lock()
+			c.sent = append(c.sent, p)
+			c.lock.Unlock()
+		case <-c.done:
+			return
+		}
+	}
+}
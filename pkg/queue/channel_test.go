@@ -0,0 +1,103 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChannel(t *testing.T) {
+	t.Parallel()
+
+	t.Run("receive", func(t *testing.T) {
+		rb := NewCircular[P, *P](4)
+		c := NewChannel[P, *P](rb)
+		p := numbered(1)
+		require.NoError(t, rb.Push(p))
+
+		assert.Equal(t, p, receive(t, c.Receive(), "Channel did not deliver the element"))
+		assert.Empty(t, c.Close())
+	})
+	t.Run("send", func(t *testing.T) {
+		rb := NewLockFree[P, *P](4)
+		c := NewChannel[P, *P](rb)
+		packets := []*P{numbered(1), numbered(2), numbered(3)}
+		for _, p := range packets {
+			c.Send() <- p
+		}
+		for _, p := range packets {
+			assert.Equal(t, p, receive(t, c.Receive(), "Channel did not deliver the element"))
+		}
+		assert.Empty(t, c.Close())
+	})
+	t.Run("select with timeout", func(t *testing.T) {
+		rb := NewCircular[P, *P](4)
+		c := NewChannel[P, *P](rb)
+		select {
+		case <-c.Receive():
+			t.Fatal("Channel delivered an element from an empty queue")
+		case <-time.After(time.Millisecond * 10):
+		}
+		c.Close()
+	})
+	t.Run("queue closed", func(t *testing.T) {
+		rb := NewCircular[P, *P](4)
+		c := NewChannel[P, *P](rb)
+		rb.Close()
+		select {
+		case _, ok := <-c.Receive():
+			assert.False(t, ok)
+		case <-time.After(time.Second):
+			t.Fatal("Channel did not close the receive channel")
+		}
+		assert.Empty(t, c.Close())
+	})
+	t.Run("queue closed with drain on close", func(t *testing.T) {
+		rb := NewLockFree[P, *P](4, WithDrainOnClose[P, *P]())
+		packets := []*P{numbered(1), numbered(2)}
+		for _, p := range packets {
+			require.NoError(t, rb.Push(p))
+		}
+		rb.Close()
+		c := NewChannel[P, *P](rb)
+		var received []*P
+		for p := range c.Receive() {
+			received = append(received, p)
+		}
+		assert.Equal(t, packets, received)
+		assert.Empty(t, c.Close())
+	})
+	t.Run("close returns undelivered elements", func(t *testing.T) {
+		rb := NewCircular[P, *P](1)
+		c := NewChannel[P, *P](rb)
+		packets := []*P{numbered(1), numbered(2), numbered(3)}
+		// The queue only holds one element, so the last send is only received once the
+		// first element was popped by the Channel and the second one was pushed.
+		for _, p := range packets {
+			c.Send() <- p
+		}
+
+		assert.Equal(t, packets, c.Close())
+		assert.Nil(t, c.Close())
+		_, ok := <-c.Receive()
+		assert.False(t, ok)
+	})
+	t.Run("send after queue closed", func(t *testing.T) {
+		rb := NewCircular[P, *P](4)
+		c := NewChannel[P, *P](rb)
+		rb.Close()
+		packets := []*P{numbered(1), numbered(2), numbered(3)}
+		for _, p := range packets {
+			select {
+			case c.Send() <- p:
+			case <-time.After(time.Second):
+				t.Fatal("Channel blocked a send after the queue was closed")
+			}
+		}
+		assert.Equal(t, packets, c.Close())
+	})
+}
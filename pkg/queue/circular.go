@@ -3,16 +3,26 @@
 package queue
 
 import (
+	"context"
 	"sync"
+	"time"
 )
 
 // Circular is a circular sized FIFO queue that uses
-// an array of fixed size to store the elements.
+// an array of fixed size to store the elements, which
+// can be changed with the Resize method.
 //
 // It is thread safe and extremely performant, however
 // it is a blocking queue and will block the caller
 // if the queue is full or if it is empty.
 type Circular[T any, P Pointer[T]] struct {
+	circular[P]
+}
+
+// circular implements Circular and CircularValue for any type of element E,
+// which is the pointer to the element for a Circular and the element itself
+// for a CircularValue.
+type circular[E any] struct {
 	_padding0 [8]uint64 //nolint:structcheck,unused
 	head      uint64
 	_padding1 [8]uint64 //nolint:structcheck,unused
@@ -28,12 +38,28 @@ type Circular[T any, P Pointer[T]] struct {
 	_padding6 [8]uint64 //nolint:structcheck,unused
 	notFull   *sync.Cond
 	_padding7 [8]uint64 //nolint:structcheck,unused
-	nodes     []P
+	nodes     []E
+	_padding8 [8]uint64 //nolint:structcheck,unused
+	graceful  bool
+	_padding9 [8]uint64 //nolint:structcheck,unused
+	stats     *Stats
 }
 
 // NewCircular creates a new circular queue with the given size.
-func NewCircular[T any, P Pointer[T]](maxSize uint64) *Circular[T, P] {
+//
+// By default Pop returns Closed as soon as the queue is closed, the WithDrainOnClose
+// option can be used to keep returning the remaining elements first.
+func NewCircular[T any, P Pointer[T]](maxSize uint64, opts ...Option[T, P]) *Circular[T, P] {
 	q := new(Circular[T, P])
+	o := newOptions(opts)
+	q.init(maxSize, o.graceful, o.stats)
+	return q
+}
+
+// init is an internal function used to initialize a queue with the given size.
+func (q *circular[E]) init(maxSize uint64, graceful bool, stats *Stats) {
+	q.graceful = graceful
+	q.stats = stats
 	q.lock = new(sync.Mutex)
 	q.notFull = sync.NewCond(q.lock)
 	q.notEmpty = sync.NewCond(q.lock)
@@ -47,12 +73,11 @@ func NewCircular[T any, P Pointer[T]](maxSize uint64) *Circular[T, P] {
 		q.maxSize = round(maxSize)
 	}
 
-	q.nodes = make([]P, q.maxSize)
-	return q
+	q.nodes = make([]E, q.maxSize)
 }
 
 // IsEmpty returns true if the queue is empty.
-func (q *Circular[T, P]) IsEmpty() (empty bool) {
+func (q *circular[E]) IsEmpty() (empty bool) {
 	q.lock.Lock()
 	empty = q.isEmpty()
 	q.lock.Unlock()
@@ -61,12 +86,12 @@ func (q *Circular[T, P]) IsEmpty() (empty bool) {
 
 // isEmpty is an internal function used to check if the
 // queue is empty.
-func (q *Circular[T, P]) isEmpty() bool {
+func (q *circular[E]) isEmpty() bool {
 	return q.head == q.tail
 }
 
 // IsFull returns true if the queue is full.
-func (q *Circular[T, P]) IsFull() (full bool) {
+func (q *circular[E]) IsFull() (full bool) {
 	q.lock.Lock()
 	full = q.isFull()
 	q.lock.Unlock()
@@ -75,14 +100,14 @@ func (q *Circular[T, P]) IsFull() (full bool) {
 
 // isFull is an internal function used to check if the
 // queue is full.
-func (q *Circular[T, P]) isFull() bool {
+func (q *circular[E]) isFull() bool {
 	return q.head == (q.tail+1)%q.maxSize
 }
 
 // IsClosed returns true if the queue is Closed
 //
 // The Drain method can be used to drain the queue after it is closed.
-func (q *Circular[T, P]) IsClosed() (closed bool) {
+func (q *circular[E]) IsClosed() (closed bool) {
 	q.lock.Lock()
 	closed = q.isClosed()
 	q.lock.Unlock()
@@ -91,12 +116,19 @@ func (q *Circular[T, P]) IsClosed() (closed bool) {
 
 // isClosed is an internal function used to check if the
 // queue is closed.
-func (q *Circular[T, P]) isClosed() bool {
+func (q *circular[E]) isClosed() bool {
 	return q.closed
 }
 
+// isDone is an internal function used to check if Pop operations
+// should return Closed, which is as soon as the queue is closed, or once
+// it is also empty if it was created with WithDrainOnClose.
+func (q *circular[E]) isDone() bool {
+	return q.closed && (!q.graceful || q.isEmpty())
+}
+
 // Length returns the number of elements in the queue.
-func (q *Circular[T, P]) Length() (size int) {
+func (q *circular[E]) Length() (size int) {
 	q.lock.Lock()
 	size = q.length()
 	q.lock.Unlock()
@@ -104,17 +136,48 @@ func (q *Circular[T, P]) Length() (size int) {
 }
 
 // length is an internal function used to get the number of elements in the queue.
-func (q *Circular[T, P]) length() int {
+func (q *circular[E]) length() int {
 	if q.tail < q.head {
 		return int(q.maxSize - q.head + q.tail)
 	}
 	return int(q.tail - q.head)
 }
 
+// Resize changes the number of elements the queue can hold, rounding it the
+// same way the constructor does, while preserving the order of the elements.
+//
+// If the new size is smaller than the current length of the queue, SizeError
+// is returned and the queue is left unchanged.
+func (q *circular[E]) Resize(maxSize uint64) error {
+	maxSize++
+	if maxSize < 2 {
+		maxSize = 2
+	} else {
+		maxSize = round(maxSize)
+	}
+	q.lock.Lock()
+	length := q.length()
+	if uint64(length) > maxSize-1 {
+		q.lock.Unlock()
+		return SizeError
+	}
+	nodes := make([]E, maxSize)
+	copyRing(nodes, q.nodes, q.head, q.tail)
+	if maxSize > q.maxSize {
+		q.notFull.Broadcast()
+	}
+	q.nodes = nodes
+	q.maxSize = maxSize
+	q.head = 0
+	q.tail = uint64(length)
+	q.lock.Unlock()
+	return nil
+}
+
 // Close closes the queue permanently.
 //
 // The Drain method can be used to drain the queue after it is closed.
-func (q *Circular[T, P]) Close() {
+func (q *circular[E]) Close() {
 	q.lock.Lock()
 	q.closed = true
 	q.notFull.Broadcast()
@@ -123,64 +186,351 @@ func (q *Circular[T, P]) Close() {
 }
 
 // Push adds an element to the queue.
-func (q *Circular[T, P]) Push(p P) error {
+func (q *circular[E]) Push(p E) error {
+	return q.PushContext(context.Background(), p)
+}
+
+// PushContext adds an element to the queue, blocking until space is available.
+//
+// If the context is cancelled while waiting, ctx.Err() is returned and the
+// element is not added to the queue.
+func (q *circular[E]) PushContext(ctx context.Context, p E) error {
+	var stop func()
+	var waited time.Time
 	q.lock.Lock()
 LOOP:
 	if q.isClosed() {
 		q.lock.Unlock()
+		q.stats.pushWaited(waited)
+		if stop != nil {
+			stop()
+		}
 		return Closed
 	}
 	if q.isFull() {
+		if err := ctx.Err(); err != nil {
+			q.lock.Unlock()
+			q.stats.pushWaited(waited)
+			if stop != nil {
+				stop()
+			}
+			return err
+		}
+		if stop == nil {
+			stop = wakeOnDone(ctx, q.notFull)
+		}
+		if waited.IsZero() {
+			waited = q.stats.now()
+		}
 		q.notFull.Wait()
 		goto LOOP
 	}
 
-	q.nodes[q.tail] = p
-	q.tail = (q.tail + 1) % q.maxSize
+	q.push(p)
+	q.stats.push(1)
 	q.notEmpty.Signal()
 	q.lock.Unlock()
+	q.stats.pushWaited(waited)
+	if stop != nil {
+		stop()
+	}
 	return nil
 }
 
+// PushDeadline adds an element to the queue, blocking until space is available
+// or the deadline passes, in which case context.DeadlineExceeded is returned.
+func (q *circular[E]) PushDeadline(p E, deadline time.Time) error {
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	err := q.PushContext(ctx, p)
+	cancel()
+	return err
+}
+
 // Pop removes an element from the queue.
-func (q *Circular[T, P]) Pop() (p P, err error) {
+func (q *circular[E]) Pop() (p E, err error) {
+	return q.PopContext(context.Background())
+}
+
+// PopContext removes an element from the queue, blocking until one is available.
+//
+// If the context is cancelled while waiting, ctx.Err() is returned.
+func (q *circular[E]) PopContext(ctx context.Context) (p E, err error) {
+	var stop func()
+	var waited time.Time
+	q.lock.Lock()
+LOOP:
+	if q.isDone() {
+		q.lock.Unlock()
+		q.stats.popWaited(waited)
+		if stop != nil {
+			stop()
+		}
+		return p, Closed
+	}
+	if q.isEmpty() {
+		if err = ctx.Err(); err != nil {
+			q.lock.Unlock()
+			q.stats.popWaited(waited)
+			if stop != nil {
+				stop()
+			}
+			return p, err
+		}
+		if stop == nil {
+			stop = wakeOnDone(ctx, q.notEmpty)
+		}
+		if waited.IsZero() {
+			waited = q.stats.now()
+		}
+		q.notEmpty.Wait()
+		goto LOOP
+	}
+
+	p = q.pop()
+	q.stats.pop(1)
+	q.notFull.Signal()
+	q.lock.Unlock()
+	q.stats.popWaited(waited)
+	if stop != nil {
+		stop()
+	}
+	return
+}
+
+// PopDeadline removes an element from the queue, blocking until one is available
+// or the deadline passes, in which case context.DeadlineExceeded is returned.
+func (q *circular[E]) PopDeadline(deadline time.Time) (E, error) {
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	p, err := q.PopContext(ctx)
+	cancel()
+	return p, err
+}
+
+// PushBatch adds all the given elements to the queue in order, returning the
+// number of elements that were added.
+//
+// As many elements as there is space for are added in a single critical section,
+// and if the queue becomes full PushBatch blocks until more space is available.
+// Elements from a batch that had to wait may be interleaved with elements
+// from other producers.
+func (q *circular[E]) PushBatch(ps []E) (n int, err error) {
+	if len(ps) == 0 {
+		return 0, nil
+	}
+	var waited time.Time
 	q.lock.Lock()
 LOOP:
 	if q.isClosed() {
 		q.lock.Unlock()
-		return nil, Closed
+		q.stats.pushWaited(waited)
+		return n, Closed
+	}
+	if q.isFull() {
+		if waited.IsZero() {
+			waited = q.stats.now()
+		}
+		q.notFull.Wait()
+		goto LOOP
+	}
+
+	pushed := n
+	for ; n < len(ps) && !q.isFull(); n++ {
+		q.push(ps[n])
+	}
+	q.stats.push(n - pushed)
+	if n-pushed > 1 {
+		q.notEmpty.Broadcast()
+	} else {
+		q.notEmpty.Signal()
+	}
+	if n < len(ps) {
+		goto LOOP
+	}
+	q.lock.Unlock()
+	q.stats.pushWaited(waited)
+	return n, nil
+}
+
+// PopBatch removes up to max elements from the queue and stores them in dst,
+// returning the number of elements that were removed.
+//
+// It blocks until at least one element is available, and then removes as many
+// elements as are available (up to max and len(dst)) in a single critical section.
+func (q *circular[E]) PopBatch(dst []E, max int) (n int, err error) {
+	if max > len(dst) {
+		max = len(dst)
+	}
+	if max <= 0 {
+		return 0, nil
+	}
+	var waited time.Time
+	q.lock.Lock()
+LOOP:
+	if q.isDone() {
+		q.lock.Unlock()
+		q.stats.popWaited(waited)
+		return 0, Closed
 	}
 	if q.isEmpty() {
+		if waited.IsZero() {
+			waited = q.stats.now()
+		}
 		q.notEmpty.Wait()
 		goto LOOP
 	}
 
+	for ; n < max && !q.isEmpty(); n++ {
+		dst[n] = q.pop()
+	}
+	q.stats.pop(n)
+	if n > 1 {
+		q.notFull.Broadcast()
+	} else {
+		q.notFull.Signal()
+	}
+	q.lock.Unlock()
+	q.stats.popWaited(waited)
+	return
+}
+
+// Peek returns the element at the start of the queue without removing it.
+//
+// If the queue is empty, EmptyError is returned.
+func (q *circular[E]) Peek() (p E, err error) {
+	q.lock.Lock()
+	if q.isDone() {
+		q.lock.Unlock()
+		return p, Closed
+	}
+	if q.isEmpty() {
+		q.lock.Unlock()
+		return p, EmptyError
+	}
 	p = q.nodes[q.head]
-	q.head = (q.head + 1) % q.maxSize
-	q.notFull.Signal()
 	q.lock.Unlock()
 	return
 }
 
-// Drain removes all elements from the queue.
-// and returns them in a slice.
+// PeekN stores up to len(dst) elements from the start of the queue in dst, in order,
+// without removing them, and returns the number of elements that were stored.
+//
+// Like Peek, it stops returning elements as soon as Pop would return Closed, so it
+// returns 0 once the queue is closed, or once it is also empty if it was created with
+// WithDrainOnClose.
+func (q *circular[E]) PeekN(dst []E) (n int) {
+	q.lock.Lock()
+	if !q.isDone() {
+		n = copyRing(dst, q.nodes, q.head, q.tail)
+	}
+	q.lock.Unlock()
+	return
+}
+
+// WaitPeek returns the element at the start of the queue without removing it,
+// blocking until one is available.
+func (q *circular[E]) WaitPeek() (E, error) {
+	return q.WaitPeekContext(context.Background())
+}
+
+// WaitPeekContext returns the element at the start of the queue without removing it,
+// blocking until one is available.
+//
+// If the context is cancelled while waiting, ctx.Err() is returned.
+func (q *circular[E]) WaitPeekContext(ctx context.Context) (p E, err error) {
+	var stop func()
+	waited := false
+	q.lock.Lock()
+LOOP:
+	if q.isDone() {
+		q.lock.Unlock()
+		if stop != nil {
+			stop()
+		}
+		return p, Closed
+	}
+	if q.isEmpty() {
+		if err = ctx.Err(); err != nil {
+			q.lock.Unlock()
+			if stop != nil {
+				stop()
+			}
+			return p, err
+		}
+		if stop == nil {
+			stop = wakeOnDone(ctx, q.notEmpty)
+		}
+		waited = true
+		q.notEmpty.Wait()
+		goto LOOP
+	}
+
+	p = q.nodes[q.head]
+	if waited {
+		// The element was not removed, so the Signal that woke this
+		// caller up is passed on to a waiting Pop, if there is one.
+		q.notEmpty.Signal()
+	}
+	q.lock.Unlock()
+	if stop != nil {
+		stop()
+	}
+	return
+}
+
+// Drain removes all elements from the queue and returns them in a slice.
 //
 // This function should only be called after the queue is closed.
-func (q *Circular[T, P]) Drain() (values []P) {
+func (q *circular[E]) Drain() (values []E) {
 	q.lock.Lock()
 	if q.isEmpty() {
 		q.lock.Unlock()
 		return nil
 	}
-	if size := int(q.head) - int(q.tail); size > 0 {
-		values = make([]P, 0, size)
-	} else {
-		values = make([]P, 0, -1*size)
-	}
-	for i := 0; i < cap(values); i++ {
-		values = append(values, q.nodes[q.head])
-		q.head = (q.head + 1) % q.maxSize
-	}
+	values = make([]E, q.length())
+	q.drainTo(values)
 	q.lock.Unlock()
 	return values
 }
+
+// DrainTo removes up to len(dst) elements from the queue and stores them in dst,
+// in order, returning the number of elements that were removed.
+//
+// It never blocks and does not allocate, so the same dst can be reused
+// to drain the queue in several steps.
+func (q *circular[E]) DrainTo(dst []E) (n int) {
+	q.lock.Lock()
+	n = q.drainTo(dst)
+	q.lock.Unlock()
+	return
+}
+
+// push is an internal function used to add an element at the tail of the
+// queue, which must not be full.
+func (q *circular[E]) push(e E) {
+	q.nodes[q.tail] = e
+	q.tail = (q.tail + 1) % q.maxSize
+}
+
+// pop is an internal function used to remove the element at the head of the
+// queue, clearing its slot so that the queue does not keep anything it refers to alive.
+func (q *circular[E]) pop() (e E) {
+	var zero E
+	e = q.nodes[q.head]
+	q.nodes[q.head] = zero
+	q.head = (q.head + 1) % q.maxSize
+	return
+}
+
+// drainTo is an internal function used to remove up to len(dst) elements
+// from the queue and store them in dst, clearing the slots they were stored in.
+func (q *circular[E]) drainTo(dst []E) int {
+	n := copyRing(dst, q.nodes, q.head, q.tail)
+	for i := 0; i < n; i++ {
+		q.pop()
+	}
+	if n > 0 {
+		q.stats.pop(n)
+		q.notFull.Broadcast()
+	}
+	return n
+}
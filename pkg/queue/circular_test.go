@@ -3,7 +3,12 @@
 package queue
 
 import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/quick"
 	"time"
 
 	"github.com/stretchr/testify/assert"
@@ -15,6 +20,25 @@ type P struct {
 	String string
 }
 
+// waitCounter is the Locker of a sync.Cond that counts how many times the Cond
+// has been waited on, since sync.Cond.Wait is the only caller of its Unlock method.
+type waitCounter struct {
+	*sync.Mutex
+	waits uint64
+}
+
+func (w *waitCounter) Unlock() {
+	atomic.AddUint64(&w.waits, 1)
+	w.Mutex.Unlock()
+}
+
+// wait blocks until the Cond has been waited on n times.
+func (w *waitCounter) wait(n uint64) {
+	for atomic.LoadUint64(&w.waits) < n {
+		runtime.Gosched()
+	}
+}
+
 func TestCircular(t *testing.T) {
 	t.Parallel()
 
@@ -311,4 +335,409 @@ func TestCircular(t *testing.T) {
 		assert.NotEqual(t, p1, p4)
 		assert.Equal(t, 2, rb.Length())
 	})
+	t.Run("push context cancelled", func(t *testing.T) {
+		rb := NewCircular[P, *P](1)
+		err := rb.Push(testPacket())
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- rb.PushContext(ctx, testPacket2())
+		}()
+		select {
+		case <-errCh:
+			t.Fatal("Circular did not block on full write")
+		case <-time.After(time.Millisecond * 10):
+		}
+		cancel()
+		select {
+		case err = <-errCh:
+			assert.ErrorIs(t, err, context.Canceled)
+		case <-time.After(time.Millisecond * 100):
+			t.Fatal("Circular did not unblock on cancelled write")
+		}
+		assert.Equal(t, 1, rb.Length())
+	})
+	t.Run("pop context cancelled", func(t *testing.T) {
+		rb := NewCircular[P, *P](1)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := rb.PopContext(ctx)
+		assert.ErrorIs(t, err, context.Canceled)
+
+		p := testPacket()
+		err = rb.Push(p)
+		require.NoError(t, err)
+		actual, err := rb.PopContext(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, p, actual)
+	})
+	t.Run("deadline exceeded", func(t *testing.T) {
+		rb := NewCircular[P, *P](1)
+		_, err := rb.PopDeadline(time.Now().Add(time.Millisecond * 10))
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+		err = rb.Push(testPacket())
+		require.NoError(t, err)
+		err = rb.PushDeadline(testPacket(), time.Now().Add(time.Millisecond*10))
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, 1, rb.Length())
+	})
+	t.Run("cancelled waiter does not lose wakeup", func(t *testing.T) {
+		rb := NewCircular[P, *P](1)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancelledCh := make(chan error, 1)
+		go func() {
+			_, err := rb.PopContext(ctx)
+			cancelledCh <- err
+		}()
+		doneCh := make(chan *P, 1)
+		go func() {
+			p, err := rb.Pop()
+			assert.NoError(t, err)
+			doneCh <- p
+		}()
+		time.Sleep(time.Millisecond * 10)
+		cancel()
+		assert.ErrorIs(t, <-cancelledCh, context.Canceled)
+
+		p := testPacket2()
+		err := rb.Push(p)
+		require.NoError(t, err)
+		select {
+		case actual := <-doneCh:
+			assert.Equal(t, p, actual)
+		case <-time.After(time.Millisecond * 100):
+			t.Fatal("Circular lost a wakeup after a cancelled Pop")
+		}
+	})
+	t.Run("closed while waiting with context", func(t *testing.T) {
+		rb := NewCircular[P, *P](1)
+		errCh := make(chan error, 1)
+		go func() {
+			_, err := rb.PopContext(context.Background())
+			errCh <- err
+		}()
+		time.Sleep(time.Millisecond * 10)
+		rb.Close()
+		assert.ErrorIs(t, <-errCh, Closed)
+	})
+	t.Run("batch", func(t *testing.T) {
+		rb := NewCircular[P, *P](4)
+		packets := make([]*P, 5)
+		for i := range packets {
+			packets[i] = testPacket()
+			packets[i].Int = i
+		}
+		n, err := rb.PushBatch(packets)
+		require.NoError(t, err)
+		assert.Equal(t, 5, n)
+		assert.Equal(t, 5, rb.Length())
+
+		dst := make([]*P, 8)
+		n, err = rb.PopBatch(dst, 2)
+		require.NoError(t, err)
+		assert.Equal(t, 2, n)
+		assert.Equal(t, packets[:2], dst[:n])
+
+		n, err = rb.PopBatch(dst, 8)
+		require.NoError(t, err)
+		assert.Equal(t, 3, n)
+		assert.Equal(t, packets[2:], dst[:n])
+
+		n, err = rb.PopBatch(dst, 0)
+		require.NoError(t, err)
+		assert.Equal(t, 0, n)
+	})
+	t.Run("batch blocking", func(t *testing.T) {
+		rb := NewCircular[P, *P](2)
+		packets := make([]*P, 8)
+		for i := range packets {
+			packets[i] = testPacket()
+			packets[i].Int = i
+		}
+		doneCh := make(chan int, 1)
+		go func() {
+			n, err := rb.PushBatch(packets)
+			assert.NoError(t, err)
+			doneCh <- n
+		}()
+
+		var received []*P
+		dst := make([]*P, 3)
+		for len(received) < len(packets) {
+			n, err := rb.PopBatch(dst, len(dst))
+			require.NoError(t, err)
+			assert.Greater(t, n, 0)
+			received = append(received, dst[:n]...)
+		}
+		assert.Equal(t, len(packets), <-doneCh)
+		assert.Equal(t, packets, received)
+	})
+	t.Run("batch closed", func(t *testing.T) {
+		rb := NewCircular[P, *P](1)
+		require.NoError(t, rb.Push(testPacket()))
+		errCh := make(chan error, 1)
+		go func() {
+			_, err := rb.PushBatch([]*P{testPacket(), testPacket2()})
+			errCh <- err
+		}()
+		time.Sleep(time.Millisecond * 10)
+		rb.Close()
+		assert.ErrorIs(t, <-errCh, Closed)
+		_, err := rb.PopBatch(make([]*P, 1), 1)
+		assert.ErrorIs(t, err, Closed)
+	})
+	t.Run("drain on close", func(t *testing.T) {
+		rb := NewCircular[P, *P](4, WithDrainOnClose[P, *P]())
+		p1 := testPacket()
+		p2 := testPacket2()
+		require.NoError(t, rb.Push(p1))
+		require.NoError(t, rb.Push(p2))
+		rb.Close()
+		assert.ErrorIs(t, rb.Push(testPacket()), Closed)
+
+		actual, err := rb.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, p1, actual)
+		actual, err = rb.PopContext(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, p2, actual)
+		_, err = rb.Pop()
+		assert.ErrorIs(t, err, Closed)
+	})
+	t.Run("drain on close unblocks waiters", func(t *testing.T) {
+		rb := NewCircular[P, *P](1, WithDrainOnClose[P, *P]())
+		errCh := make(chan error, 1)
+		go func() {
+			_, err := rb.Pop()
+			errCh <- err
+		}()
+		time.Sleep(time.Millisecond * 10)
+		rb.Close()
+		assert.ErrorIs(t, <-errCh, Closed)
+	})
+	t.Run("resize", func(t *testing.T) {
+		rb := NewCircular[P, *P](3)
+		packets := make([]*P, 5)
+		for i := range packets {
+			packets[i] = testPacket()
+			packets[i].Int = i
+		}
+		for _, p := range packets[:3] {
+			require.NoError(t, rb.Push(p))
+		}
+		for _, p := range packets[:2] {
+			actual, err := rb.Pop()
+			require.NoError(t, err)
+			assert.Equal(t, p, actual)
+		}
+		for _, p := range packets[3:] {
+			require.NoError(t, rb.Push(p))
+		}
+		assert.Equal(t, 3, rb.Length())
+		assert.Less(t, rb.tail, rb.head)
+
+		assert.ErrorIs(t, rb.Resize(1), SizeError)
+		assert.Equal(t, 3, rb.Length())
+
+		require.NoError(t, rb.Resize(16))
+		assert.Equal(t, uint64(32), rb.maxSize)
+		assert.Equal(t, 3, rb.Length())
+		for _, p := range packets[2:] {
+			actual, err := rb.Pop()
+			require.NoError(t, err)
+			assert.Equal(t, p, actual)
+		}
+
+		require.NoError(t, rb.Resize(0))
+		assert.Equal(t, uint64(2), rb.maxSize)
+		require.NoError(t, rb.Push(packets[0]))
+		assert.True(t, rb.IsFull())
+	})
+	t.Run("resize wakes blocked push", func(t *testing.T) {
+		rb := NewCircular[P, *P](1)
+		p1 := testPacket()
+		p2 := testPacket2()
+		require.NoError(t, rb.Push(p1))
+		doneCh := make(chan error, 1)
+		go func() {
+			doneCh <- rb.Push(p2)
+		}()
+		select {
+		case <-doneCh:
+			t.Fatal("Circular did not block on full write")
+		case <-time.After(time.Millisecond * 10):
+		}
+		require.NoError(t, rb.Resize(2))
+		select {
+		case err := <-doneCh:
+			require.NoError(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("Circular did not unblock on resize")
+		}
+		assert.Equal(t, []*P{p1, p2}, []*P{mustPop(t, rb), mustPop(t, rb)})
+	})
+	t.Run("peek", func(t *testing.T) {
+		rb := NewCircular[P, *P](3)
+		_, err := rb.Peek()
+		assert.ErrorIs(t, err, EmptyError)
+		packets := make([]*P, 5)
+		for i := range packets {
+			packets[i] = testPacket()
+			packets[i].Int = i
+		}
+		for _, p := range packets[:3] {
+			require.NoError(t, rb.Push(p))
+		}
+		for _, p := range packets[:2] {
+			actual, err := rb.Pop()
+			require.NoError(t, err)
+			assert.Same(t, p, actual)
+		}
+		for _, p := range packets[3:] {
+			require.NoError(t, rb.Push(p))
+		}
+
+		actual, err := rb.Peek()
+		require.NoError(t, err)
+		assert.Same(t, packets[2], actual)
+		dst := make([]*P, 2)
+		assert.Equal(t, 2, rb.PeekN(dst))
+		assert.Equal(t, packets[2:4], dst)
+		dst = make([]*P, 4)
+		assert.Equal(t, 3, rb.PeekN(dst))
+		assert.Equal(t, packets[2:], dst[:3])
+		assert.Equal(t, 3, rb.Length())
+
+		rb.Close()
+		_, err = rb.Peek()
+		assert.ErrorIs(t, err, Closed)
+		assert.Equal(t, 0, rb.PeekN(dst))
+	})
+	t.Run("wait peek", func(t *testing.T) {
+		// A WaitPeek and a Pop wait on the same condition, in the order given by
+		// first. If the WaitPeek is woken by the push it has to pass the wakeup on
+		// to the Pop, and if the Pop is woken it takes the element, so the WaitPeek
+		// only returns once the queue is closed.
+		type result struct {
+			p   *P
+			err error
+		}
+		for _, peekFirst := range []bool{true, false} {
+			rb := NewCircular[P, *P](1)
+			waiters := &waitCounter{Mutex: rb.lock}
+			rb.notEmpty = sync.NewCond(waiters)
+			p := testPacket()
+			peekCh := make(chan result, 1)
+			popCh := make(chan result, 1)
+			peek := func() {
+				actual, err := rb.WaitPeek()
+				peekCh <- result{actual, err}
+			}
+			pop := func() {
+				actual, err := rb.Pop()
+				popCh <- result{actual, err}
+			}
+			if !peekFirst {
+				peek, pop = pop, peek
+			}
+			go peek()
+			waiters.wait(1)
+			go pop()
+			waiters.wait(2)
+			require.NoError(t, rb.Push(p))
+
+			select {
+			case r := <-popCh:
+				require.NoError(t, r.err)
+				assert.Same(t, p, r.p)
+			case <-time.After(time.Second):
+				t.Fatal("Circular did not unblock pop on push")
+			}
+			rb.Close()
+			select {
+			case r := <-peekCh:
+				if peekFirst {
+					require.NoError(t, r.err)
+					assert.Same(t, p, r.p)
+				} else {
+					assert.ErrorIs(t, r.err, Closed)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("Circular did not unblock wait peek")
+			}
+		}
+
+		rb := NewCircular[P, *P](1)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+		defer cancel()
+		_, err := rb.WaitPeekContext(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+	t.Run("drain wrapped", func(t *testing.T) {
+		// Random sequences of pushes and pops move the head and tail around the
+		// ring, and whatever is left is drained either at once or in chunks.
+		property := func(size uint8, ops []bool, chunk uint8) bool {
+			rb := NewCircular[P, *P](uint64(size%16) + 1)
+			var model []*P
+			for _, op := range ops {
+				if op {
+					if rb.IsFull() {
+						continue
+					}
+					p := &P{Int: len(model)}
+					if rb.Push(p) != nil {
+						return false
+					}
+					model = append(model, p)
+					continue
+				}
+				if rb.IsEmpty() {
+					if len(model) != 0 {
+						return false
+					}
+					continue
+				}
+				p, err := rb.Pop()
+				if err != nil || p != model[0] {
+					return false
+				}
+				model = model[1:]
+			}
+			if rb.Length() != len(model) {
+				return false
+			}
+			var values []*P
+			if chunk%4 == 0 {
+				values = rb.Drain()
+			} else {
+				dst := make([]*P, chunk%4)
+				for {
+					n := rb.DrainTo(dst)
+					if n == 0 {
+						break
+					}
+					values = append(values, dst[:n]...)
+				}
+			}
+			if len(values) != len(model) || !rb.IsEmpty() {
+				return false
+			}
+			for i := range model {
+				if values[i] != model[i] {
+					return false
+				}
+			}
+			return rb.Drain() == nil
+		}
+		require.NoError(t, quick.Check(property, &quick.Config{MaxCount: 1000}))
+	})
+}
+
+func mustPop(t *testing.T, rb *Circular[P, *P]) *P {
+	p, err := rb.Pop()
+	require.NoError(t, err)
+	return p
 }
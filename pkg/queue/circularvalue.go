@@ -0,0 +1,22 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+// CircularValue is a Circular queue that stores its elements by value in the
+// ring instead of storing pointers to them, so that small elements like
+// sequence numbers or indices can be queued without allocating them on the heap.
+//
+// It shares its implementation with Circular, and supports the same operations.
+type CircularValue[T any] struct {
+	circular[T]
+}
+
+// NewCircularValue creates a new CircularValue queue with the given size.
+//
+// It accepts the same options as NewCircular.
+func NewCircularValue[T any](maxSize uint64, opts ...Option[T, *T]) *CircularValue[T] {
+	q := new(CircularValue[T])
+	o := newOptions(opts)
+	q.init(maxSize, o.graceful, o.stats)
+	return q
+}
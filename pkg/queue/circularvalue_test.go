@@ -0,0 +1,118 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircularValue(t *testing.T) {
+	t.Parallel()
+
+	t.Run("success", func(t *testing.T) {
+		rb := NewCircularValue[P](1)
+		require.NoError(t, rb.Push(P{Int: 1, String: "1"}))
+		actual, err := rb.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, P{Int: 1, String: "1"}, actual)
+		assert.True(t, rb.IsEmpty())
+	})
+	t.Run("wrap around", func(t *testing.T) {
+		rb := NewCircularValue[uint64](3)
+		for i := uint64(0); i < 10; i++ {
+			require.NoError(t, rb.Push(i))
+			require.NoError(t, rb.Push(i+100))
+			v, err := rb.Pop()
+			require.NoError(t, err)
+			assert.Equal(t, i, v)
+			v, err = rb.Pop()
+			require.NoError(t, err)
+			assert.Equal(t, i+100, v)
+		}
+		assert.Equal(t, 0, rb.Length())
+	})
+	t.Run("blocking", func(t *testing.T) {
+		rb := NewCircularValue[int](1)
+		require.NoError(t, rb.Push(1))
+		assert.True(t, rb.IsFull())
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+		defer cancel()
+		assert.ErrorIs(t, rb.PushContext(ctx, 2), context.DeadlineExceeded)
+
+		doneCh := make(chan error, 1)
+		go func() {
+			doneCh <- rb.Push(2)
+		}()
+		select {
+		case <-doneCh:
+			t.Fatal("CircularValue did not block on full write")
+		case <-time.After(time.Millisecond * 10):
+		}
+		v, err := rb.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, 1, v)
+		select {
+		case err = <-doneCh:
+			require.NoError(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("CircularValue did not unblock on pop")
+		}
+		v, err = rb.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, 2, v)
+
+		ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*10)
+		defer cancel()
+		v, err = rb.PopContext(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Zero(t, v)
+	})
+	t.Run("close", func(t *testing.T) {
+		rb := NewCircularValue[int](4)
+		for i := 0; i < 3; i++ {
+			require.NoError(t, rb.Push(i))
+		}
+		rb.Close()
+		assert.True(t, rb.IsClosed())
+		assert.ErrorIs(t, rb.Push(3), Closed)
+		_, err := rb.Pop()
+		assert.ErrorIs(t, err, Closed)
+		assert.Equal(t, []int{0, 1, 2}, rb.Drain())
+		assert.Nil(t, rb.Drain())
+	})
+	t.Run("drain on close", func(t *testing.T) {
+		rb := NewCircularValue[int](4, WithDrainOnClose[int, *int]())
+		require.NoError(t, rb.Push(1))
+		rb.Close()
+		v, err := rb.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, 1, v)
+		_, err = rb.Pop()
+		assert.ErrorIs(t, err, Closed)
+	})
+	t.Run("peek and resize", func(t *testing.T) {
+		rb := NewCircularValue[int](2)
+		require.NoError(t, rb.Push(1))
+		require.NoError(t, rb.Push(2))
+		v, err := rb.Peek()
+		require.NoError(t, err)
+		assert.Equal(t, 1, v)
+
+		require.NoError(t, rb.Resize(4))
+		n, err := rb.PushBatch([]int{3, 4})
+		require.NoError(t, err)
+		assert.Equal(t, 2, n)
+		dst := make([]int, 3)
+		assert.Equal(t, 3, rb.PeekN(dst))
+		assert.Equal(t, []int{1, 2, 3}, dst)
+		assert.Equal(t, 3, rb.DrainTo(dst))
+		assert.Equal(t, []int{1, 2, 3}, dst)
+		assert.Equal(t, []int{4}, rb.Drain())
+	})
+}
@@ -0,0 +1,122 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue_test
+
+import (
+	"testing"
+
+	"github.com/loopholelabs/common/pkg/queue"
+	"github.com/loopholelabs/common/pkg/queue/queuetest"
+)
+
+func TestConformance(t *testing.T) {
+	t.Parallel()
+
+	t.Run("circular", func(t *testing.T) {
+		queuetest.RunBlocking(t, func(size uint64) queue.BlockingQueue[queuetest.Element, *queuetest.Element] {
+			return queue.NewCircular[queuetest.Element, *queuetest.Element](size)
+		})
+		queuetest.RunDrainOnClose(t, func(size uint64) queue.Queue[queuetest.Element, *queuetest.Element] {
+			return queue.NewCircular[queuetest.Element, *queuetest.Element](size,
+				queue.WithDrainOnClose[queuetest.Element, *queuetest.Element]())
+		})
+	})
+	t.Run("non-blocking", func(t *testing.T) {
+		queuetest.Run(t, func(size uint64) queue.Queue[queuetest.Element, *queuetest.Element] {
+			return queue.NewNonBlocking[queuetest.Element, *queuetest.Element](size)
+		})
+		queuetest.RunDrainOnClose(t, func(size uint64) queue.Queue[queuetest.Element, *queuetest.Element] {
+			return queue.NewNonBlocking[queuetest.Element, *queuetest.Element](size,
+				queue.WithDrainOnClose[queuetest.Element, *queuetest.Element]())
+		})
+	})
+	t.Run("lockfree", func(t *testing.T) {
+		queuetest.RunBlocking(t, func(size uint64) queue.BlockingQueue[queuetest.Element, *queuetest.Element] {
+			return queue.NewLockFree[queuetest.Element, *queuetest.Element](size)
+		})
+		queuetest.RunDrainOnClose(t, func(size uint64) queue.Queue[queuetest.Element, *queuetest.Element] {
+			return queue.NewLockFree[queuetest.Element, *queuetest.Element](size,
+				queue.WithDrainOnClose[queuetest.Element, *queuetest.Element]())
+		})
+	})
+	t.Run("lockfree with park", func(t *testing.T) {
+		queuetest.RunBlocking(t, func(size uint64) queue.BlockingQueue[queuetest.Element, *queuetest.Element] {
+			return queue.NewLockFree[queuetest.Element, *queuetest.Element](size,
+				queue.WithWaitStrategy[queuetest.Element, *queuetest.Element](queue.NewPark(16)))
+		})
+	})
+	t.Run("priority", func(t *testing.T) {
+		less := func(*queuetest.Element, *queuetest.Element) bool {
+			return false
+		}
+		queuetest.Run(t, func(size uint64) queue.Queue[queuetest.Element, *queuetest.Element] {
+			return queue.NewPriority[queuetest.Element, *queuetest.Element](less, size)
+		})
+		queuetest.RunDrainOnClose(t, func(size uint64) queue.Queue[queuetest.Element, *queuetest.Element] {
+			return queue.NewPriority[queuetest.Element, *queuetest.Element](less, size,
+				queue.WithDrainOnClose[queuetest.Element, *queuetest.Element]())
+		})
+	})
+	t.Run("unbounded", func(t *testing.T) {
+		queuetest.RunUnbounded(t, func(size uint64) queue.BlockingQueue[queuetest.Element, *queuetest.Element] {
+			return queue.NewUnbounded[queuetest.Element, *queuetest.Element](1,
+				queue.WithShrinkAfter[queuetest.Element, *queuetest.Element](1))
+		})
+		queuetest.RunDrainOnClose(t, func(size uint64) queue.Queue[queuetest.Element, *queuetest.Element] {
+			return queue.NewUnbounded[queuetest.Element, *queuetest.Element](1,
+				queue.WithDrainOnClose[queuetest.Element, *queuetest.Element]())
+		})
+	})
+	t.Run("sharded", func(t *testing.T) {
+		queuetest.RunBlocking(t, func(size uint64) queue.BlockingQueue[queuetest.Element, *queuetest.Element] {
+			return queue.NewSharded[queuetest.Element, *queuetest.Element](1, size,
+				queue.WithWaitStrategy[queuetest.Element, *queuetest.Element](queue.NewPark(16)))
+		})
+		queuetest.RunDrainOnClose(t, func(size uint64) queue.Queue[queuetest.Element, *queuetest.Element] {
+			return queue.NewSharded[queuetest.Element, *queuetest.Element](1, size,
+				queue.WithDrainOnClose[queuetest.Element, *queuetest.Element]())
+		})
+	})
+	t.Run("sharded with 4 shards", func(t *testing.T) {
+		queuetest.RunRelaxed(t, func(size uint64) queue.BlockingQueue[queuetest.Element, *queuetest.Element] {
+			return queue.NewSharded[queuetest.Element, *queuetest.Element](4, (size+3)/4,
+				queue.WithWaitStrategy[queuetest.Element, *queuetest.Element](queue.NewPark(16)))
+		})
+	})
+	t.Run("budget", func(t *testing.T) {
+		queuetest.RunBlocking(t, func(size uint64) queue.BlockingQueue[queuetest.Element, *queuetest.Element] {
+			return queue.NewBudget[queuetest.Element, *queuetest.Element](size)
+		})
+		queuetest.RunDrainOnClose(t, func(size uint64) queue.Queue[queuetest.Element, *queuetest.Element] {
+			return queue.NewBudget[queuetest.Element, *queuetest.Element](size,
+				queue.WithDrainOnClose[queuetest.Element, *queuetest.Element]())
+		})
+	})
+	t.Run("circular value", func(t *testing.T) {
+		queuetest.RunBlocking(t, func(size uint64) queue.BlockingQueue[queuetest.Element, *queuetest.Element] {
+			return queuetest.BlockingValues(queue.NewCircularValue[queuetest.Element](size))
+		})
+		queuetest.RunDrainOnClose(t, func(size uint64) queue.Queue[queuetest.Element, *queuetest.Element] {
+			return queuetest.Values(queue.NewCircularValue[queuetest.Element](size,
+				queue.WithDrainOnClose[queuetest.Element, *queuetest.Element]()))
+		})
+	})
+	t.Run("non-blocking value", func(t *testing.T) {
+		queuetest.Run(t, func(size uint64) queue.Queue[queuetest.Element, *queuetest.Element] {
+			return queuetest.Values(queue.NewNonBlockingValue[queuetest.Element](size))
+		})
+		queuetest.RunDrainOnClose(t, func(size uint64) queue.Queue[queuetest.Element, *queuetest.Element] {
+			return queuetest.Values(queue.NewNonBlockingValue[queuetest.Element](size,
+				queue.WithDrainOnClose[queuetest.Element, *queuetest.Element]()))
+		})
+	})
+	t.Run("lockfree value", func(t *testing.T) {
+		queuetest.RunBlocking(t, func(size uint64) queue.BlockingQueue[queuetest.Element, *queuetest.Element] {
+			return queuetest.BlockingValues(queue.NewLockFreeValue[queuetest.Element](size))
+		})
+		queuetest.RunDrainOnClose(t, func(size uint64) queue.Queue[queuetest.Element, *queuetest.Element] {
+			return queuetest.Values(queue.NewLockFreeValue[queuetest.Element](size,
+				queue.WithDrainOnClose[queuetest.Element, *queuetest.Element]()))
+		})
+	})
+}
@@ -0,0 +1,213 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Delay is a queue that releases each element once its scheduled time has passed,
+// in order of their scheduled times. Elements scheduled for the same time are
+// released in FIFO order.
+//
+// It is thread safe, and a single Delay queue can replace an arbitrary number of
+// per-element timers. Pop blocks the caller until the earliest element is ready.
+type Delay[T any, P Pointer[T]] struct {
+	_padding0 [8]uint64 //nolint:structcheck,unused
+	heap      *heap[T, P]
+	_padding1 [8]uint64 //nolint:structcheck,unused
+	closed    bool
+	_padding2 [8]uint64 //nolint:structcheck,unused
+	lock      *sync.Mutex
+	_padding3 [8]uint64 //nolint:structcheck,unused
+	changed   chan struct{}
+	_padding4 [8]uint64 //nolint:structcheck,unused
+	graceful  bool
+	_padding5 [8]uint64 //nolint:structcheck,unused
+	stats     *Stats
+}
+
+// NewDelay creates a new Delay queue.
+//
+// By default Pop returns Closed as soon as the queue is closed, the WithDrainOnClose
+// option can be used to keep returning the remaining elements (once they are ready) first.
+func NewDelay[T any, P Pointer[T]](opts ...Option[T, P]) *Delay[T, P] {
+	q := new(Delay[T, P])
+	o := newOptions(opts)
+	q.graceful = o.graceful
+	q.stats = o.stats
+	q.lock = new(sync.Mutex)
+	q.changed = make(chan struct{})
+	q.heap = newHeap(func(a *entry[T, P], b *entry[T, P]) bool {
+		return a.deadline.Before(b.deadline)
+	})
+	return q
+}
+
+// IsEmpty returns true if the queue is empty.
+func (q *Delay[T, P]) IsEmpty() (empty bool) {
+	q.lock.Lock()
+	empty = q.isEmpty()
+	q.lock.Unlock()
+	return
+}
+
+// isEmpty is an internal function used to check if the
+// queue is empty.
+func (q *Delay[T, P]) isEmpty() bool {
+	return q.heap.length() == 0
+}
+
+// IsClosed returns true if the queue is Closed
+//
+// The Drain method can be used to drain the queue after it is closed.
+func (q *Delay[T, P]) IsClosed() (closed bool) {
+	q.lock.Lock()
+	closed = q.isClosed()
+	q.lock.Unlock()
+	return
+}
+
+// isClosed is an internal function used to check if the
+// queue is closed.
+func (q *Delay[T, P]) isClosed() bool {
+	return q.closed
+}
+
+// isDone is an internal function used to check if Pop operations
+// should return Closed, which is as soon as the queue is closed, or once
+// it is also empty if it was created with WithDrainOnClose.
+func (q *Delay[T, P]) isDone() bool {
+	return q.closed && (!q.graceful || q.isEmpty())
+}
+
+// Length returns the number of elements in the queue, including
+// the ones that are not ready yet.
+func (q *Delay[T, P]) Length() (size int) {
+	q.lock.Lock()
+	size = q.heap.length()
+	q.lock.Unlock()
+	return
+}
+
+// Close closes the queue permanently.
+//
+// The Drain method can be used to drain the queue after it is closed.
+func (q *Delay[T, P]) Close() {
+	q.lock.Lock()
+	q.closed = true
+	q.notify()
+	q.lock.Unlock()
+}
+
+// notify is an internal function used to wake up all the
+// callers that are waiting for the queue to change.
+func (q *Delay[T, P]) notify() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+// Push adds an element to the queue that will be ready at the given time.
+func (q *Delay[T, P]) Push(p P, readyAt time.Time) error {
+	q.lock.Lock()
+	if q.isClosed() {
+		q.lock.Unlock()
+		return Closed
+	}
+	q.heap.push(entry[T, P]{value: p, deadline: readyAt})
+	q.stats.push(1)
+	if q.heap.peek().value == p {
+		q.notify()
+	}
+	q.lock.Unlock()
+	return nil
+}
+
+// PushAfter adds an element to the queue that will be ready
+// once the given delay has passed.
+func (q *Delay[T, P]) PushAfter(p P, delay time.Duration) error {
+	return q.Push(p, time.Now().Add(delay))
+}
+
+// Pop removes the earliest element from the queue, blocking until
+// its scheduled time has passed.
+func (q *Delay[T, P]) Pop() (P, error) {
+	return q.PopContext(context.Background())
+}
+
+// PopContext removes the earliest element from the queue, blocking until
+// its scheduled time has passed.
+//
+// If the context is cancelled while waiting, ctx.Err() is returned.
+func (q *Delay[T, P]) PopContext(ctx context.Context) (P, error) {
+	var timer *time.Timer
+	var waited time.Time
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+		q.stats.popWaited(waited)
+	}()
+	for {
+		var timeout <-chan time.Time
+		q.lock.Lock()
+		if q.isDone() {
+			q.lock.Unlock()
+			return nil, Closed
+		}
+		if !q.isEmpty() {
+			delay := time.Until(q.heap.peek().deadline)
+			if delay <= 0 {
+				p := q.heap.pop().value
+				q.stats.pop(1)
+				q.lock.Unlock()
+				return p, nil
+			}
+			if timer == nil {
+				timer = time.NewTimer(delay)
+			} else {
+				timer.Reset(delay)
+			}
+			timeout = timer.C
+		}
+		changed := q.changed
+		q.lock.Unlock()
+		if waited.IsZero() {
+			waited = q.stats.now()
+		}
+
+		select {
+		case <-changed:
+			if timer != nil && !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		case <-timeout:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Drain removes all elements from the queue, regardless of whether
+// they are ready, and returns them in a slice in order of their scheduled times.
+//
+// This function should only be called after the queue is closed.
+func (q *Delay[T, P]) Drain() (values []P) {
+	q.lock.Lock()
+	if q.isEmpty() {
+		q.lock.Unlock()
+		return nil
+	}
+	values = make([]P, 0, q.heap.length())
+	for !q.isEmpty() {
+		values = append(values, q.heap.pop().value)
+	}
+	q.stats.pop(len(values))
+	q.lock.Unlock()
+	return values
+}
@@ -0,0 +1,128 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDelay(t *testing.T) {
+	t.Parallel()
+
+	t.Run("ordering", func(t *testing.T) {
+		q := NewDelay[P, *P]()
+		now := time.Now()
+		require.NoError(t, q.Push(numbered(3), now.Add(time.Millisecond*30)))
+		require.NoError(t, q.Push(numbered(1), now.Add(time.Millisecond*10)))
+		require.NoError(t, q.Push(numbered(2), now.Add(time.Millisecond*20)))
+		assert.Equal(t, 3, q.Length())
+
+		for i := 1; i <= 3; i++ {
+			p, err := q.Pop()
+			require.NoError(t, err)
+			assert.Equal(t, i, p.Int)
+			assert.GreaterOrEqual(t, time.Since(now), time.Millisecond*time.Duration(10*i))
+		}
+		assert.True(t, q.IsEmpty())
+	})
+	t.Run("same deadline is fifo", func(t *testing.T) {
+		q := NewDelay[P, *P]()
+		readyAt := time.Now()
+		for i := 0; i < 4; i++ {
+			require.NoError(t, q.Push(numbered(i), readyAt))
+		}
+		for i := 0; i < 4; i++ {
+			p, err := q.Pop()
+			require.NoError(t, err)
+			assert.Equal(t, i, p.Int)
+		}
+	})
+	t.Run("earlier push wakes waiting pop", func(t *testing.T) {
+		q := NewDelay[P, *P]()
+		require.NoError(t, q.PushAfter(numbered(1), time.Hour))
+		done := make(chan *P, 1)
+		go func() {
+			p, err := q.Pop()
+			assert.NoError(t, err)
+			done <- p
+		}()
+		time.Sleep(time.Millisecond * 10)
+		require.NoError(t, q.PushAfter(numbered(2), time.Millisecond*10))
+		assert.Equal(t, 2, receive(t, done, "Delay did not release the earlier element").Int)
+		assert.Equal(t, 1, q.Length())
+	})
+	t.Run("pop context", func(t *testing.T) {
+		q := NewDelay[P, *P]()
+		require.NoError(t, q.PushAfter(numbered(1), time.Hour))
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+		defer cancel()
+		_, err := q.PopContext(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, 1, q.Length())
+	})
+	t.Run("close", func(t *testing.T) {
+		q := NewDelay[P, *P]()
+		p := numbered(1)
+		require.NoError(t, q.PushAfter(p, time.Hour))
+		errCh := make(chan error, 1)
+		go func() {
+			_, err := q.Pop()
+			errCh <- err
+		}()
+		time.Sleep(time.Millisecond * 10)
+		q.Close()
+		assert.True(t, q.IsClosed())
+		assert.ErrorIs(t, receive(t, errCh, "Delay did not unblock on close"), Closed)
+		assert.ErrorIs(t, q.PushAfter(numbered(2), 0), Closed)
+		assert.Equal(t, []*P{p}, q.Drain())
+		assert.Nil(t, q.Drain())
+	})
+	t.Run("drain on close", func(t *testing.T) {
+		q := NewDelay[P, *P](WithDrainOnClose[P, *P]())
+		require.NoError(t, q.PushAfter(numbered(1), time.Millisecond*10))
+		q.Close()
+		p, err := q.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, 1, p.Int)
+		_, err = q.Pop()
+		assert.ErrorIs(t, err, Closed)
+	})
+	t.Run("concurrent", func(t *testing.T) {
+		const consumers = 4
+		const items = 256
+		q := NewDelay[P, *P]()
+		now := time.Now()
+		for i := 0; i < items; i++ {
+			require.NoError(t, q.Push(numbered(i), now.Add(time.Duration(i%16)*time.Millisecond)))
+		}
+		var wg sync.WaitGroup
+		received := make(chan int, items)
+		for i := 0; i < consumers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					p, err := q.Pop()
+					if err != nil {
+						return
+					}
+					received <- p.Int
+				}
+			}()
+		}
+		seen := make(map[int]bool, items)
+		for i := 0; i < items; i++ {
+			v := receive(t, received, "Delay did not release all the elements")
+			require.False(t, seen[v])
+			seen[v] = true
+		}
+		q.Close()
+		wg.Wait()
+	})
+}
@@ -0,0 +1,158 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+// dequeArray is the circular array backing a Deque, which is replaced by
+// a larger copy whenever the Deque runs out of space.
+//
+// Its slots are only ever accessed atomically, since a thief can read a slot
+// while the owner is storing a new element in it.
+type dequeArray[T any, P Pointer[T]] struct {
+	mask  int64
+	nodes []unsafe.Pointer
+}
+
+// newDequeArray creates a new dequeArray with the given size, which must be a power of 2.
+func newDequeArray[T any, P Pointer[T]](size int64) *dequeArray[T, P] {
+	return &dequeArray[T, P]{
+		mask:  size - 1,
+		nodes: make([]unsafe.Pointer, size),
+	}
+}
+
+// get returns the element stored in the slot for the given index.
+func (a *dequeArray[T, P]) get(index int64) P {
+	return P(atomic.LoadPointer(&a.nodes[index&a.mask]))
+}
+
+// put stores the given element in the slot for the given index.
+func (a *dequeArray[T, P]) put(index int64, p P) {
+	atomic.StorePointer(&a.nodes[index&a.mask], unsafe.Pointer(p))
+}
+
+// grow returns a copy of the array with twice the size, containing
+// the elements between the given top and bottom indices.
+func (a *dequeArray[T, P]) grow(top int64, bottom int64) *dequeArray[T, P] {
+	grown := newDequeArray[T, P]((a.mask + 1) << 1)
+	for i := top; i < bottom; i++ {
+		grown.put(i, a.get(i))
+	}
+	return grown
+}
+
+// Deque is an unbounded lock-free work-stealing deque, based on the
+// Chase-Lev algorithm, that is meant to be used as the local queue of a worker.
+//
+// The worker that owns the Deque pushes and pops elements at the bottom in LIFO order,
+// while any number of other workers can steal elements from the top in FIFO order.
+// The owner only ever contends with thieves for the last element in the Deque.
+//
+// Push and Pop must only be called by the owner, one at a time. Steal, Length
+// and IsEmpty are safe to call concurrently from any goroutine.
+type Deque[T any, P Pointer[T]] struct {
+	_padding0 [8]uint64 //nolint:structcheck,unused
+	top       int64
+	_padding1 [8]uint64 //nolint:structcheck,unused
+	bottom    int64
+	_padding2 [8]uint64 //nolint:structcheck,unused
+	array     unsafe.Pointer
+}
+
+// NewDeque creates a new Deque whose backing array initially holds the given number
+// of elements, rounded up to the nearest power of 2. The array doubles in size
+// whenever the owner pushes an element into a full Deque.
+func NewDeque[T any, P Pointer[T]](initialSize uint64) *Deque[T, P] {
+	q := new(Deque[T, P])
+	if initialSize < 2 {
+		initialSize = 2
+	}
+	q.array = unsafe.Pointer(newDequeArray[T, P](int64(round(initialSize))))
+	return q
+}
+
+// load returns the current backing array.
+func (q *Deque[T, P]) load() *dequeArray[T, P] {
+	return (*dequeArray[T, P])(atomic.LoadPointer(&q.array))
+}
+
+// Push adds an element to the bottom of the Deque, growing it if it is full.
+//
+// It must only be called by the owner of the Deque.
+func (q *Deque[T, P]) Push(p P) {
+	bottom := atomic.LoadInt64(&q.bottom)
+	top := atomic.LoadInt64(&q.top)
+	a := q.load()
+	if bottom-top > a.mask {
+		a = a.grow(top, bottom)
+		atomic.StorePointer(&q.array, unsafe.Pointer(a))
+	}
+	a.put(bottom, p)
+	atomic.StoreInt64(&q.bottom, bottom+1)
+}
+
+// Pop removes the element at the bottom of the Deque, which is the one that was
+// pushed most recently, returning EmptyError if the Deque is empty.
+//
+// It must only be called by the owner of the Deque.
+func (q *Deque[T, P]) Pop() (P, error) {
+	bottom := atomic.LoadInt64(&q.bottom) - 1
+	a := q.load()
+	atomic.StoreInt64(&q.bottom, bottom)
+	top := atomic.LoadInt64(&q.top)
+	if top > bottom {
+		atomic.StoreInt64(&q.bottom, bottom+1)
+		return nil, EmptyError
+	}
+
+	p := a.get(bottom)
+	if top < bottom {
+		a.put(bottom, nil)
+		return p, nil
+	}
+
+	// This is the last element, so the owner has to race the thieves for it.
+	stolen := !atomic.CompareAndSwapInt64(&q.top, top, top+1)
+	atomic.StoreInt64(&q.bottom, bottom+1)
+	if stolen {
+		return nil, EmptyError
+	}
+	return p, nil
+}
+
+// Steal removes the element at the top of the Deque, which is the oldest one,
+// returning EmptyError if the Deque is empty.
+//
+// It is safe to be called concurrently by any number of goroutines other than the
+// owner, and retries whenever another thief (or the owner) takes the element first.
+func (q *Deque[T, P]) Steal() (P, error) {
+	for {
+		top := atomic.LoadInt64(&q.top)
+		bottom := atomic.LoadInt64(&q.bottom)
+		if top >= bottom {
+			return nil, EmptyError
+		}
+		p := q.load().get(top)
+		if atomic.CompareAndSwapInt64(&q.top, top, top+1) {
+			return p, nil
+		}
+	}
+}
+
+// Length returns the number of elements in the Deque.
+func (q *Deque[T, P]) Length() int {
+	top := atomic.LoadInt64(&q.top)
+	if size := atomic.LoadInt64(&q.bottom) - top; size > 0 {
+		return int(size)
+	}
+	return 0
+}
+
+// IsEmpty returns whether the Deque is currently empty
+func (q *Deque[T, P]) IsEmpty() bool {
+	return q.Length() == 0
+}
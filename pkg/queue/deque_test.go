@@ -0,0 +1,113 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeque(t *testing.T) {
+	t.Parallel()
+
+	t.Run("owner lifo", func(t *testing.T) {
+		q := NewDeque[P, *P](4)
+		for i := 0; i < 3; i++ {
+			q.Push(numbered(i))
+		}
+		assert.Equal(t, 3, q.Length())
+		for i := 2; i >= 0; i-- {
+			p, err := q.Pop()
+			require.NoError(t, err)
+			assert.Equal(t, i, p.Int)
+		}
+		_, err := q.Pop()
+		assert.ErrorIs(t, err, EmptyError)
+		assert.True(t, q.IsEmpty())
+	})
+	t.Run("steal fifo", func(t *testing.T) {
+		q := NewDeque[P, *P](4)
+		_, err := q.Steal()
+		assert.ErrorIs(t, err, EmptyError)
+		for i := 0; i < 3; i++ {
+			q.Push(numbered(i))
+		}
+		p, err := q.Steal()
+		require.NoError(t, err)
+		assert.Equal(t, 0, p.Int)
+		p, err = q.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, 2, p.Int)
+		p, err = q.Steal()
+		require.NoError(t, err)
+		assert.Equal(t, 1, p.Int)
+		_, err = q.Steal()
+		assert.ErrorIs(t, err, EmptyError)
+	})
+	t.Run("grow", func(t *testing.T) {
+		q := NewDeque[P, *P](2)
+		q.Push(numbered(0))
+		_, err := q.Steal()
+		require.NoError(t, err)
+		for i := 1; i <= 9; i++ {
+			q.Push(numbered(i))
+		}
+		assert.Equal(t, 9, q.Length())
+		assert.Equal(t, int64(15), q.load().mask)
+		for i := 1; i <= 9; i++ {
+			p, err := q.Steal()
+			require.NoError(t, err)
+			assert.Equal(t, i, p.Int)
+		}
+	})
+	t.Run("concurrency", func(t *testing.T) {
+		const thieves = 4
+		const count = 20000
+		q := NewDeque[P, *P](8)
+		seen := make([]uint32, count)
+		var done uint32
+		var wg sync.WaitGroup
+		for i := 0; i < thieves; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					p, err := q.Steal()
+					if err != nil {
+						if atomic.LoadUint32(&done) == 1 && q.IsEmpty() {
+							return
+						}
+						runtime.Gosched()
+						continue
+					}
+					atomic.AddUint32(&seen[p.Int], 1)
+				}
+			}()
+		}
+		for i := 0; i < count; i++ {
+			q.Push(numbered(i))
+			if i%3 == 0 {
+				if p, err := q.Pop(); err == nil {
+					atomic.AddUint32(&seen[p.Int], 1)
+				}
+			}
+		}
+		for {
+			p, err := q.Pop()
+			if err != nil {
+				break
+			}
+			atomic.AddUint32(&seen[p.Int], 1)
+		}
+		atomic.StoreUint32(&done, 1)
+		wg.Wait()
+		for i := range seen {
+			require.Equal(t, uint32(1), atomic.LoadUint32(&seen[i]), "element %d", i)
+		}
+	})
+}
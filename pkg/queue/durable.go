@@ -0,0 +1,667 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// segmentExtension is the file extension of the log segments of a Durable queue.
+	segmentExtension = ".log"
+
+	// ackFile is the name of the file a Durable queue stores its acknowledged sequence in.
+	ackFile = "ack"
+
+	// recordHeaderSize is the size of the header of every record in a log segment,
+	// which holds the length of the encoded element and its CRC32 checksum.
+	recordHeaderSize = 8
+
+	// DefaultSegmentSize is the size a log segment of a Durable queue can grow to
+	// before a new segment is started, unless WithSegmentSize is used.
+	DefaultSegmentSize = 16 << 20
+)
+
+var (
+	CorruptedError = errors.New("queue log is corrupted")
+	AckError       = errors.New("sequence has not been popped")
+)
+
+// Codec encodes and decodes the elements of a Durable queue.
+type Codec[T any, P Pointer[T]] interface {
+	// Encode returns the encoded form of the given element.
+	Encode(P) ([]byte, error)
+
+	// Decode returns the element encoded in the given bytes, which
+	// must not be retained after Decode returns.
+	Decode([]byte) (P, error)
+}
+
+// Durable is a FIFO queue that persists every element to a segmented append-only
+// log on disk, so that the elements it holds survive a crash, while keeping a bounded
+// number of the next elements to be popped decoded in memory. Elements that do not fit
+// in memory are only kept on disk, and are read back once there is room for them.
+//
+// Every element is identified by a sequence number which is returned by Pop. Popped
+// elements stay in the log until they are acknowledged with Ack, and when the queue
+// is reopened every element that was not acknowledged is returned again, so each
+// element is delivered at least once. Log segments are deleted once every element
+// in them has been acknowledged.
+//
+// Elements are written to the log with a single write system call, which survives the
+// process crashing, and the Sync method can be used to also make them survive the
+// machine crashing. A record that was only partially written when the process crashed
+// is discarded when the queue is reopened.
+//
+// The elements in memory are kept in the same ring a Circular queue uses, which is
+// only accessed under the lock of the Durable queue.
+//
+// It is thread safe, Push never blocks, and Pop blocks the caller if the queue is empty.
+type Durable[T any, P Pointer[T]] struct {
+	_padding0     [8]uint64 //nolint:structcheck,unused
+	readSequence  uint64
+	_padding1     [8]uint64 //nolint:structcheck,unused
+	memSequence   uint64
+	_padding2     [8]uint64 //nolint:structcheck,unused
+	writeSequence uint64
+	_padding3     [8]uint64 //nolint:structcheck,unused
+	acked         uint64
+	_padding4     [8]uint64 //nolint:structcheck,unused
+	maxSize       uint64
+	_padding5     [8]uint64 //nolint:structcheck,unused
+	memory        *circular[P]
+	_padding6     [8]uint64 //nolint:structcheck,unused
+	closed        bool
+	_padding7     [8]uint64 //nolint:structcheck,unused
+	lock          *sync.Mutex
+	_padding8     [8]uint64 //nolint:structcheck,unused
+	notEmpty      *sync.Cond
+	_padding9     [8]uint64 //nolint:structcheck,unused
+	codec         Codec[T, P]
+	_padding10    [8]uint64 //nolint:structcheck,unused
+	directory     string
+	_padding11    [8]uint64 //nolint:structcheck,unused
+	segmentSize   int64
+	_padding12    [8]uint64 //nolint:structcheck,unused
+	segments      []uint64
+	_padding13    [8]uint64 //nolint:structcheck,unused
+	writer        *os.File
+	_padding14    [8]uint64 //nolint:structcheck,unused
+	writerSize    int64
+	_padding15    [8]uint64 //nolint:structcheck,unused
+	reader        *os.File
+	_padding16    [8]uint64 //nolint:structcheck,unused
+	readerSegment int
+	_padding17    [8]uint64 //nolint:structcheck,unused
+	readerOffset  int64
+	_padding18    [8]uint64 //nolint:structcheck,unused
+	readerSize    int64
+	_padding19    [8]uint64 //nolint:structcheck,unused
+	stats         *Stats
+}
+
+// OpenDurable opens the Durable queue stored in the given directory, creating
+// it if it does not exist, and recovers every element that was pushed to it
+// but not acknowledged.
+//
+// At most maxSize elements are kept in memory. The WithSegmentSize option
+// can be used to change the size of the log segments, and with the WithStats
+// option the elements recovered from the log are recorded as pushed.
+func OpenDurable[T any, P Pointer[T]](directory string, codec Codec[T, P], maxSize uint64, opts ...Option[T, P]) (*Durable[T, P], error) {
+	if err := os.MkdirAll(directory, 0o755); err != nil {
+		return nil, err
+	}
+	if maxSize < 1 {
+		maxSize = 1
+	}
+	o := newOptions(opts)
+	q := new(Durable[T, P])
+	q.lock = new(sync.Mutex)
+	q.notEmpty = sync.NewCond(q.lock)
+	q.codec = codec
+	q.directory = directory
+	q.maxSize = maxSize
+	q.stats = o.stats
+	q.segmentSize = o.segmentSize
+	if q.segmentSize <= 0 {
+		q.segmentSize = DefaultSegmentSize
+	}
+	q.memory = new(circular[P])
+	q.memory.init(maxSize, false, nil)
+
+	if err := q.recover(); err != nil {
+		_ = q.closeFiles()
+		return nil, err
+	}
+	q.stats.push(int(q.writeSequence - q.readSequence))
+	return q, nil
+}
+
+// segmentPath returns the path of the log segment whose first element has the given sequence.
+func (q *Durable[T, P]) segmentPath(first uint64) string {
+	return filepath.Join(q.directory, fmt.Sprintf("%020d%s", first, segmentExtension))
+}
+
+// recover is an internal function used to rebuild the state of the queue from
+// the log segments and the ack file in its directory.
+func (q *Durable[T, P]) recover() error {
+	entries, err := os.ReadDir(q.directory)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExtension) {
+			continue
+		}
+		first, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExtension), 10, 64)
+		if err != nil {
+			continue
+		}
+		q.segments = append(q.segments, first)
+	}
+	sort.Slice(q.segments, func(i int, j int) bool {
+		return q.segments[i] < q.segments[j]
+	})
+
+	if data, err := os.ReadFile(filepath.Join(q.directory, ackFile)); err == nil && len(data) == 8 {
+		q.acked = binary.LittleEndian.Uint64(data)
+	} else if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if len(q.segments) == 0 {
+		q.segments = append(q.segments, q.acked)
+		q.writeSequence = q.acked
+	} else if q.segments[0] > q.acked {
+		q.acked = q.segments[0]
+	}
+	q.readSequence = q.acked
+
+	// Every segment is scanned to find the end of the log, and the
+	// offset of the first element that has not been acknowledged.
+	q.readerSegment = -1
+	for i, first := range q.segments {
+		count, size, err := q.scan(first, i == len(q.segments)-1)
+		if err != nil {
+			return err
+		}
+		if q.readerSegment == -1 && q.readSequence < first+count {
+			q.readerSegment = i
+		}
+		if i == len(q.segments)-1 {
+			q.writeSequence = first + count
+			q.writerSize = size
+		}
+	}
+	if q.readSequence > q.writeSequence {
+		return fmt.Errorf("%w: acknowledged sequence %d is past the end of the log", CorruptedError, q.readSequence)
+	}
+	if q.readerSegment == -1 {
+		q.readerSegment = len(q.segments) - 1
+		q.readerOffset = q.writerSize
+	} else if err = q.seek(q.readSequence - q.segments[q.readerSegment]); err != nil {
+		return err
+	}
+	q.memSequence = q.readSequence
+
+	q.writer, err = os.OpenFile(q.segmentPath(q.segments[len(q.segments)-1]), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	return q.load()
+}
+
+// scan is an internal function used to count the valid records in the log segment
+// whose first element has the given sequence, returning the number of records and
+// the size of the segment they occupy.
+//
+// A record that is incomplete or fails its checksum is only tolerated in the last
+// segment, where it is the result of a crash in the middle of a write, and the
+// segment is truncated to remove it.
+func (q *Durable[T, P]) scan(first uint64, last bool) (count uint64, size int64, err error) {
+	path := q.segmentPath(first)
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, 0, nil
+		}
+		return 0, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return 0, 0, err
+	}
+	for {
+		length, err := readRecord(f, info.Size(), size, nil)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			_ = f.Close()
+			if !last || !errors.Is(err, CorruptedError) {
+				return 0, 0, fmt.Errorf("segment %s at offset %d: %w", path, size, err)
+			}
+			if err = os.Truncate(path, size); err != nil {
+				return 0, 0, err
+			}
+			return count, size, nil
+		}
+		size += recordHeaderSize + int64(length)
+		count++
+	}
+	return count, size, f.Close()
+}
+
+// readRecord reads the record at the given offset of the given file, which holds
+// size bytes, verifying its checksum, and returns the length of its payload. If buf
+// is large enough, the payload is stored in it, otherwise a new buffer is allocated.
+//
+// It returns io.EOF if there is no record at the given offset, and CorruptedError if
+// the record is incomplete, fails its checksum, or has a length that runs past the end
+// of the file, so that a corrupted length never makes it allocate a huge buffer.
+func readRecord(f *os.File, size int64, offset int64, buf *[]byte) (uint32, error) {
+	var header [recordHeaderSize]byte
+	n, err := f.ReadAt(header[:], offset)
+	if n == 0 && err == io.EOF {
+		return 0, io.EOF
+	}
+	if n < recordHeaderSize {
+		if err == io.EOF {
+			return 0, fmt.Errorf("%w: incomplete record header", CorruptedError)
+		}
+		return 0, err
+	}
+	length := binary.LittleEndian.Uint32(header[0:4])
+	if int64(length) > size-offset-recordHeaderSize {
+		return 0, fmt.Errorf("%w: record length %d runs past the end of the segment", CorruptedError, length)
+	}
+	var payload []byte
+	if buf != nil && uint32(cap(*buf)) >= length {
+		payload = (*buf)[:length]
+	} else {
+		payload = make([]byte, length)
+	}
+	if n, err = f.ReadAt(payload, offset+recordHeaderSize); n < len(payload) {
+		if err == io.EOF {
+			return 0, fmt.Errorf("%w: incomplete record", CorruptedError)
+		}
+		return 0, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(header[4:8]) {
+		return 0, fmt.Errorf("%w: checksum mismatch", CorruptedError)
+	}
+	if buf != nil {
+		*buf = payload
+	}
+	return length, nil
+}
+
+// seek is an internal function used to move the reader past the given
+// number of records at the start of the reader's segment.
+func (q *Durable[T, P]) seek(records uint64) error {
+	if err := q.openReader(); err != nil {
+		return err
+	}
+	q.readerOffset = 0
+	for i := uint64(0); i < records; i++ {
+		length, err := readRecord(q.reader, q.readerSize, q.readerOffset, nil)
+		if err != nil {
+			return err
+		}
+		q.readerOffset += recordHeaderSize + int64(length)
+	}
+	return nil
+}
+
+// openReader is an internal function used to open the reader's segment if it
+// is not already open, and to update the number of bytes that can be read from it.
+func (q *Durable[T, P]) openReader() error {
+	if q.readerSegment == len(q.segments)-1 {
+		q.readerSize = q.writerSize
+	}
+	if q.reader != nil {
+		return nil
+	}
+	reader, err := os.Open(q.segmentPath(q.segments[q.readerSegment]))
+	if err != nil {
+		return err
+	}
+	if q.readerSegment < len(q.segments)-1 {
+		info, err := reader.Stat()
+		if err != nil {
+			_ = reader.Close()
+			return err
+		}
+		q.readerSize = info.Size()
+	}
+	q.reader = reader
+	return nil
+}
+
+// load is an internal function used to read elements that are only on disk
+// back into memory, until the memory is full or every element is in memory.
+func (q *Durable[T, P]) load() error {
+	var buf []byte
+	for q.memSequence < q.writeSequence && uint64(q.memory.length()) < q.maxSize {
+		if err := q.openReader(); err != nil {
+			return err
+		}
+		length, err := readRecord(q.reader, q.readerSize, q.readerOffset, &buf)
+		if err == io.EOF && q.readerSegment < len(q.segments)-1 {
+			_ = q.reader.Close()
+			q.reader = nil
+			q.readerSegment++
+			q.readerOffset = 0
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		p, err := q.codec.Decode(buf)
+		if err != nil {
+			return err
+		}
+		q.memory.push(p)
+		q.readerOffset += recordHeaderSize + int64(length)
+		q.memSequence++
+	}
+	return nil
+}
+
+// IsEmpty returns true if every element that was pushed has been popped.
+func (q *Durable[T, P]) IsEmpty() (empty bool) {
+	q.lock.Lock()
+	empty = q.isEmpty()
+	q.lock.Unlock()
+	return
+}
+
+// isEmpty is an internal function used to check if the
+// queue is empty.
+func (q *Durable[T, P]) isEmpty() bool {
+	return q.readSequence == q.writeSequence
+}
+
+// IsClosed returns true if the queue is Closed
+func (q *Durable[T, P]) IsClosed() (closed bool) {
+	q.lock.Lock()
+	closed = q.closed
+	q.lock.Unlock()
+	return
+}
+
+// Length returns the number of elements that have not been popped yet,
+// including the ones that are only stored on disk.
+func (q *Durable[T, P]) Length() (size int) {
+	q.lock.Lock()
+	size = int(q.writeSequence - q.readSequence)
+	q.lock.Unlock()
+	return
+}
+
+// Unacknowledged returns the number of elements that have been
+// popped but not acknowledged yet.
+func (q *Durable[T, P]) Unacknowledged() (size int) {
+	q.lock.Lock()
+	size = int(q.readSequence - q.acked)
+	q.lock.Unlock()
+	return
+}
+
+// Push appends an element to the log, and keeps it in memory if
+// there is room for it and every earlier element is also in memory.
+//
+// SizeError is returned if the encoded element does not fit in a record,
+// whose length is stored in 32 bits.
+func (q *Durable[T, P]) Push(p P) error {
+	data, err := q.codec.Encode(p)
+	if err != nil {
+		return err
+	}
+	if uint64(len(data)) > math.MaxUint32 {
+		return fmt.Errorf("%w: encoded element of %d bytes does not fit in a record", SizeError, len(data))
+	}
+	record := make([]byte, recordHeaderSize+len(data))
+	binary.LittleEndian.PutUint32(record[0:4], uint32(len(data)))
+	binary.LittleEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(data))
+	copy(record[recordHeaderSize:], data)
+
+	q.lock.Lock()
+	if q.closed {
+		q.lock.Unlock()
+		return Closed
+	}
+	if q.writerSize > 0 && q.writerSize+int64(len(record)) > q.segmentSize {
+		if err = q.roll(); err != nil {
+			q.lock.Unlock()
+			return err
+		}
+	}
+	if _, err = q.writer.Write(record); err != nil {
+		// Remove anything that was partially written, so that
+		// the next record is not appended after a corrupted one.
+		_ = q.writer.Truncate(q.writerSize)
+		q.lock.Unlock()
+		return err
+	}
+	q.writerSize += int64(len(record))
+	if q.memSequence == q.writeSequence && uint64(q.memory.length()) < q.maxSize {
+		q.memory.push(p)
+		q.memSequence++
+		if q.readerSegment != len(q.segments)-1 && q.reader != nil {
+			_ = q.reader.Close()
+			q.reader = nil
+		}
+		q.readerSegment = len(q.segments) - 1
+		q.readerOffset = q.writerSize
+	}
+	q.writeSequence++
+	q.stats.push(1)
+	q.notEmpty.Signal()
+	q.lock.Unlock()
+	return nil
+}
+
+// roll is an internal function used to start a new log segment.
+func (q *Durable[T, P]) roll() error {
+	writer, err := os.OpenFile(q.segmentPath(q.writeSequence), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if q.readerSegment == len(q.segments)-1 {
+		q.readerSize = q.writerSize
+	}
+	_ = q.writer.Close()
+	q.writer = writer
+	q.writerSize = 0
+	q.segments = append(q.segments, q.writeSequence)
+	return nil
+}
+
+// Pop removes the next element from the queue, blocking until one is available,
+// and returns it along with its sequence number, which must be passed to Ack
+// once the element has been processed.
+func (q *Durable[T, P]) Pop() (P, uint64, error) {
+	return q.PopContext(context.Background())
+}
+
+// PopContext removes the next element from the queue the same way Pop does,
+// however if the context is cancelled while waiting, ctx.Err() is returned.
+func (q *Durable[T, P]) PopContext(ctx context.Context) (p P, sequence uint64, err error) {
+	var stop func()
+	var waited time.Time
+	q.lock.Lock()
+LOOP:
+	if q.closed {
+		q.lock.Unlock()
+		q.stats.popWaited(waited)
+		if stop != nil {
+			stop()
+		}
+		return nil, 0, Closed
+	}
+	if q.isEmpty() {
+		if err = ctx.Err(); err != nil {
+			q.lock.Unlock()
+			q.stats.popWaited(waited)
+			if stop != nil {
+				stop()
+			}
+			return nil, 0, err
+		}
+		if stop == nil {
+			stop = wakeOnDone(ctx, q.notEmpty)
+		}
+		if waited.IsZero() {
+			waited = q.stats.now()
+		}
+		q.notEmpty.Wait()
+		goto LOOP
+	}
+
+	if q.memory.isEmpty() {
+		if err = q.load(); err != nil {
+			q.lock.Unlock()
+			q.stats.popWaited(waited)
+			if stop != nil {
+				stop()
+			}
+			return nil, 0, err
+		}
+	}
+	p = q.memory.pop()
+	sequence = q.readSequence
+	q.readSequence++
+	q.stats.pop(1)
+	q.lock.Unlock()
+	q.stats.popWaited(waited)
+	if stop != nil {
+		stop()
+	}
+	return
+}
+
+// Ack acknowledges every element up to and including the one with the given sequence
+// number, so that they are not returned again when the queue is reopened, and deletes
+// the log segments that only contain acknowledged elements.
+//
+// Acknowledgements are cumulative, so with multiple consumers the caller is responsible
+// for only acknowledging a sequence once every element before it has been processed.
+// AckError is returned if the element with the given sequence has not been popped yet.
+func (q *Durable[T, P]) Ack(sequence uint64) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.closed {
+		return Closed
+	}
+	if sequence >= q.readSequence {
+		return AckError
+	}
+	if sequence < q.acked {
+		return nil
+	}
+
+	if err := q.writeAck(sequence + 1); err != nil {
+		return err
+	}
+	q.acked = sequence + 1
+
+	for len(q.segments) > 1 && q.readerSegment > 0 && q.segments[1] <= q.acked {
+		if err := os.Remove(q.segmentPath(q.segments[0])); err != nil {
+			return err
+		}
+		q.segments = q.segments[1:]
+		q.readerSegment--
+	}
+	return nil
+}
+
+// writeAck is an internal function used to replace the ack file with one holding the
+// given acknowledged sequence. The new file is synced before it is renamed over the old
+// one, and the directory is synced after, so that a crash leaves either the old or the
+// new acknowledged sequence on disk, and the rename itself is durable once it returns.
+func (q *Durable[T, P]) writeAck(acked uint64) error {
+	var data [8]byte
+	binary.LittleEndian.PutUint64(data[:], acked)
+	path := filepath.Join(q.directory, ackFile)
+	f, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data[:]); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	directory, err := os.Open(q.directory)
+	if err != nil {
+		return err
+	}
+	err = directory.Sync()
+	if closeErr := directory.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Sync commits the log to stable storage, so that every element
+// that was pushed survives the machine crashing.
+func (q *Durable[T, P]) Sync() error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.closed {
+		return Closed
+	}
+	return q.writer.Sync()
+}
+
+// Close closes the queue and its log files, and returns any waiting Pop calls.
+//
+// The elements that were not acknowledged are returned again when the queue is reopened.
+func (q *Durable[T, P]) Close() error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.closed {
+		return nil
+	}
+	q.closed = true
+	q.notEmpty.Broadcast()
+	return q.closeFiles()
+}
+
+// closeFiles is an internal function used to close the open log segments.
+func (q *Durable[T, P]) closeFiles() (err error) {
+	if q.reader != nil {
+		err = q.reader.Close()
+		q.reader = nil
+	}
+	if q.writer != nil {
+		if closeErr := q.writer.Close(); err == nil {
+			err = closeErr
+		}
+		q.writer = nil
+	}
+	return
+}
@@ -0,0 +1,261 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Encode(p *P) ([]byte, error) {
+	return json.Marshal(p)
+}
+
+func (jsonCodec) Decode(data []byte) (*P, error) {
+	p := new(P)
+	return p, json.Unmarshal(data, p)
+}
+
+func TestDurable(t *testing.T) {
+	t.Parallel()
+
+	segments := func(t *testing.T, directory string) []string {
+		matches, err := filepath.Glob(filepath.Join(directory, "*"+segmentExtension))
+		require.NoError(t, err)
+		return matches
+	}
+
+	t.Run("spill to disk", func(t *testing.T) {
+		q, err := OpenDurable[P, *P](t.TempDir(), jsonCodec{}, 2)
+		require.NoError(t, err)
+		defer q.Close()
+		for i := 0; i < 10; i++ {
+			require.NoError(t, q.Push(&P{Int: i, String: "packet"}))
+		}
+		assert.Equal(t, 10, q.Length())
+		assert.Equal(t, 2, q.memory.length())
+		for i := 0; i < 10; i++ {
+			p, sequence, err := q.Pop()
+			require.NoError(t, err)
+			assert.Equal(t, i, p.Int)
+			assert.Equal(t, "packet", p.String)
+			assert.Equal(t, uint64(i), sequence)
+			assert.LessOrEqual(t, q.memory.length(), 2)
+		}
+		assert.True(t, q.IsEmpty())
+		assert.Equal(t, 10, q.Unacknowledged())
+	})
+	t.Run("recover unacknowledged", func(t *testing.T) {
+		directory := t.TempDir()
+		q, err := OpenDurable[P, *P](directory, jsonCodec{}, 4)
+		require.NoError(t, err)
+		for i := 0; i < 6; i++ {
+			require.NoError(t, q.Push(numbered(i)))
+		}
+		for i := 0; i < 3; i++ {
+			_, _, err = q.Pop()
+			require.NoError(t, err)
+		}
+		require.NoError(t, q.Ack(1))
+		assert.ErrorIs(t, q.Ack(3), AckError)
+		require.NoError(t, q.Sync())
+		require.NoError(t, q.Close())
+		_, _, err = q.Pop()
+		assert.ErrorIs(t, err, Closed)
+
+		q, err = OpenDurable[P, *P](directory, jsonCodec{}, 4)
+		require.NoError(t, err)
+		defer q.Close()
+		assert.Equal(t, 4, q.Length())
+		for i := 2; i < 6; i++ {
+			p, sequence, err := q.Pop()
+			require.NoError(t, err)
+			assert.Equal(t, i, p.Int)
+			assert.Equal(t, uint64(i), sequence)
+		}
+		require.NoError(t, q.Push(numbered(6)))
+		p, sequence, err := q.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, 6, p.Int)
+		assert.Equal(t, uint64(6), sequence)
+	})
+	t.Run("truncate acknowledged segments", func(t *testing.T) {
+		directory := t.TempDir()
+		q, err := OpenDurable[P, *P](directory, jsonCodec{}, 2, WithSegmentSize[P, *P](64))
+		require.NoError(t, err)
+		for i := 0; i < 10; i++ {
+			require.NoError(t, q.Push(numbered(i)))
+		}
+		before := len(segments(t, directory))
+		assert.Greater(t, before, 2)
+
+		for i := 0; i < 8; i++ {
+			_, _, err = q.Pop()
+			require.NoError(t, err)
+		}
+		require.NoError(t, q.Ack(7))
+		after := len(segments(t, directory))
+		assert.Less(t, after, before)
+		require.NoError(t, q.Close())
+
+		q, err = OpenDurable[P, *P](directory, jsonCodec{}, 2, WithSegmentSize[P, *P](64))
+		require.NoError(t, err)
+		defer q.Close()
+		assert.Equal(t, 2, q.Length())
+		for i := 8; i < 10; i++ {
+			p, sequence, err := q.Pop()
+			require.NoError(t, err)
+			assert.Equal(t, i, p.Int)
+			assert.Equal(t, uint64(i), sequence)
+		}
+	})
+	t.Run("torn write", func(t *testing.T) {
+		directory := t.TempDir()
+		q, err := OpenDurable[P, *P](directory, jsonCodec{}, 2)
+		require.NoError(t, err)
+		for i := 0; i < 3; i++ {
+			require.NoError(t, q.Push(numbered(i)))
+		}
+		require.NoError(t, q.Close())
+
+		files := segments(t, directory)
+		require.Len(t, files, 1)
+		info, err := os.Stat(files[0])
+		require.NoError(t, err)
+		f, err := os.OpenFile(files[0], os.O_WRONLY|os.O_APPEND, 0o644)
+		require.NoError(t, err)
+		_, err = f.Write([]byte{42, 0, 0, 0, 1, 2})
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		q, err = OpenDurable[P, *P](directory, jsonCodec{}, 2)
+		require.NoError(t, err)
+		defer q.Close()
+		info2, err := os.Stat(files[0])
+		require.NoError(t, err)
+		assert.Equal(t, info.Size(), info2.Size())
+		assert.Equal(t, 3, q.Length())
+		require.NoError(t, q.Push(numbered(3)))
+		for i := 0; i < 4; i++ {
+			p, _, err := q.Pop()
+			require.NoError(t, err)
+			assert.Equal(t, i, p.Int)
+		}
+	})
+	t.Run("corrupted segment", func(t *testing.T) {
+		directory := t.TempDir()
+		q, err := OpenDurable[P, *P](directory, jsonCodec{}, 2, WithSegmentSize[P, *P](64))
+		require.NoError(t, err)
+		for i := 0; i < 6; i++ {
+			require.NoError(t, q.Push(numbered(i)))
+		}
+		require.NoError(t, q.Close())
+
+		files := segments(t, directory)
+		require.Greater(t, len(files), 1)
+		data, err := os.ReadFile(files[0])
+		require.NoError(t, err)
+		data[len(data)-1] ^= 0xFF
+		require.NoError(t, os.WriteFile(files[0], data, 0o644))
+
+		_, err = OpenDurable[P, *P](directory, jsonCodec{}, 2, WithSegmentSize[P, *P](64))
+		assert.ErrorIs(t, err, CorruptedError)
+	})
+	t.Run("corrupted record length", func(t *testing.T) {
+		directory := t.TempDir()
+		q, err := OpenDurable[P, *P](directory, jsonCodec{}, 2)
+		require.NoError(t, err)
+		require.NoError(t, q.Push(numbered(0)))
+		require.NoError(t, q.Close())
+
+		files := segments(t, directory)
+		require.Len(t, files, 1)
+		data, err := os.ReadFile(files[0])
+		require.NoError(t, err)
+		binary.LittleEndian.PutUint32(data[0:4], math.MaxUint32)
+		require.NoError(t, os.WriteFile(files[0], data, 0o644))
+
+		f, err := os.Open(files[0])
+		require.NoError(t, err)
+		_, err = readRecord(f, int64(len(data)), 0, nil)
+		assert.ErrorIs(t, err, CorruptedError)
+		require.NoError(t, f.Close())
+
+		q, err = OpenDurable[P, *P](directory, jsonCodec{}, 2)
+		require.NoError(t, err)
+		defer q.Close()
+		assert.Equal(t, 0, q.Length())
+	})
+	t.Run("spill across segments", func(t *testing.T) {
+		q, err := OpenDurable[P, *P](t.TempDir(), jsonCodec{}, 1, WithSegmentSize[P, *P](256))
+		require.NoError(t, err)
+		defer q.Close()
+		for _, batch := range [][2]int{{0, 2}, {2, 10}} {
+			for i := batch[0]; i < batch[1]; i++ {
+				require.NoError(t, q.Push(numbered(i)))
+			}
+			for i := batch[0]; i < batch[1]; i++ {
+				p, _, err := q.Pop()
+				require.NoError(t, err)
+				assert.Equal(t, i, p.Int)
+			}
+		}
+		assert.Greater(t, len(q.segments), 1)
+	})
+	t.Run("pop blocks until push", func(t *testing.T) {
+		q, err := OpenDurable[P, *P](t.TempDir(), jsonCodec{}, 1)
+		require.NoError(t, err)
+		defer q.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+		defer cancel()
+		_, _, err = q.PopContext(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+		doneCh := make(chan *P, 1)
+		go func() {
+			p, _, err := q.Pop()
+			assert.NoError(t, err)
+			doneCh <- p
+		}()
+		time.Sleep(time.Millisecond * 10)
+		require.NoError(t, q.Push(numbered(1)))
+		assert.Equal(t, 1, receive(t, doneCh, "Durable did not unblock on push").Int)
+	})
+	t.Run("stats", func(t *testing.T) {
+		directory := t.TempDir()
+		stats := NewStats()
+		q, err := OpenDurable[P, *P](directory, jsonCodec{}, 2, WithStats[P, *P](stats))
+		require.NoError(t, err)
+		for i := 0; i < 4; i++ {
+			require.NoError(t, q.Push(numbered(i)))
+		}
+		_, _, err = q.Pop()
+		require.NoError(t, err)
+		snapshot := stats.Snapshot()
+		assert.Equal(t, uint64(4), snapshot.Pushes)
+		assert.Equal(t, uint64(1), snapshot.Pops)
+		assert.Equal(t, 3, snapshot.Depth)
+		assert.Equal(t, 4, snapshot.Peak)
+		require.NoError(t, q.Close())
+
+		stats = NewStats()
+		q, err = OpenDurable[P, *P](directory, jsonCodec{}, 2, WithStats[P, *P](stats))
+		require.NoError(t, err)
+		defer q.Close()
+		snapshot = stats.Snapshot()
+		assert.Equal(t, uint64(4), snapshot.Pushes)
+		assert.Equal(t, 4, snapshot.Depth)
+	})
+}
@@ -0,0 +1,365 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// flow is the sub-queue of a single key in a Fair queue.
+type flow[K comparable, T any, P Pointer[T]] struct {
+	key     K
+	ring    *ring[P]
+	weight  uint64
+	deficit uint64
+	waiters int
+	notFull *sync.Cond
+}
+
+// Fair is a multi-tenant FIFO queue that keeps a separate sub-queue per key, and
+// pops from the keys with elements in weighted round-robin order, so that a single
+// key cannot fill the queue and starve all the other keys.
+//
+// Every key gets a turn of as many consecutive elements as its weight (which is
+// 1 by default, see SetWeight), after which the next key with elements gets its turn.
+// Elements with the same key are always popped in the order they were pushed.
+//
+// It is thread safe, Push blocks the caller if the sub-queue of the key is full, and
+// Pop blocks the caller if every sub-queue is empty.
+type Fair[K comparable, T any, P Pointer[T]] struct {
+	_padding0 [8]uint64 //nolint:structcheck,unused
+	flows     map[K]*flow[K, T, P]
+	_padding1 [8]uint64 //nolint:structcheck,unused
+	active    *ring[*flow[K, T, P]]
+	_padding2 [8]uint64 //nolint:structcheck,unused
+	weights   map[K]uint64
+	_padding3 [8]uint64 //nolint:structcheck,unused
+	length    uint64
+	_padding4 [8]uint64 //nolint:structcheck,unused
+	keySize   uint64
+	_padding5 [8]uint64 //nolint:structcheck,unused
+	closed    bool
+	_padding6 [8]uint64 //nolint:structcheck,unused
+	lock      *sync.Mutex
+	_padding7 [8]uint64 //nolint:structcheck,unused
+	notEmpty  *sync.Cond
+	_padding8 [8]uint64 //nolint:structcheck,unused
+	graceful  bool
+	_padding9 [8]uint64 //nolint:structcheck,unused
+	stats     *Stats
+}
+
+// NewFair creates a new Fair queue where the sub-queue of every key
+// can hold at most keySize elements.
+//
+// By default Pop returns Closed as soon as the queue is closed, the WithDrainOnClose
+// option can be used to keep returning the remaining elements first.
+func NewFair[K comparable, T any, P Pointer[T]](keySize uint64, opts ...Option[T, P]) *Fair[K, T, P] {
+	q := new(Fair[K, T, P])
+	if keySize < 1 {
+		keySize = 1
+	}
+	o := newOptions(opts)
+	q.graceful = o.graceful
+	q.stats = o.stats
+	q.keySize = keySize
+	q.lock = new(sync.Mutex)
+	q.notEmpty = sync.NewCond(q.lock)
+	q.flows = make(map[K]*flow[K, T, P])
+	q.weights = make(map[K]uint64)
+	q.active = newRing[*flow[K, T, P]](1)
+	return q
+}
+
+// SetWeight sets the number of consecutive elements the given key can pop
+// during its turn, relative to the other keys. A weight of 0 resets the key
+// to the default weight of 1.
+func (q *Fair[K, T, P]) SetWeight(key K, weight uint64) {
+	q.lock.Lock()
+	if weight <= 1 {
+		delete(q.weights, key)
+		weight = 1
+	} else {
+		q.weights[key] = weight
+	}
+	if f, ok := q.flows[key]; ok {
+		f.weight = weight
+	}
+	q.lock.Unlock()
+}
+
+// flow is an internal function used to get the sub-queue of the
+// given key, creating it if it does not exist.
+func (q *Fair[K, T, P]) flow(key K) *flow[K, T, P] {
+	f, ok := q.flows[key]
+	if !ok {
+		f = &flow[K, T, P]{
+			key:     key,
+			ring:    newRing[P](1),
+			weight:  1,
+			notFull: sync.NewCond(q.lock),
+		}
+		if weight, ok := q.weights[key]; ok {
+			f.weight = weight
+		}
+		q.flows[key] = f
+	}
+	return f
+}
+
+// release is an internal function used to remove the sub-queue of the given
+// key once it is empty and no Push is waiting on it, so that keys which are
+// no longer used do not hold on to any memory.
+func (q *Fair[K, T, P]) release(f *flow[K, T, P]) {
+	if f.ring.length() == 0 && f.waiters == 0 {
+		delete(q.flows, f.key)
+	}
+}
+
+// IsEmpty returns true if the queue is empty.
+func (q *Fair[K, T, P]) IsEmpty() (empty bool) {
+	q.lock.Lock()
+	empty = q.isEmpty()
+	q.lock.Unlock()
+	return
+}
+
+// isEmpty is an internal function used to check if the
+// queue is empty.
+func (q *Fair[K, T, P]) isEmpty() bool {
+	return q.length == 0
+}
+
+// IsClosed returns true if the queue is Closed
+//
+// The Drain method can be used to drain the queue after it is closed.
+func (q *Fair[K, T, P]) IsClosed() (closed bool) {
+	q.lock.Lock()
+	closed = q.closed
+	q.lock.Unlock()
+	return
+}
+
+// isDone is an internal function used to check if Pop operations
+// should return Closed, which is as soon as the queue is closed, or once
+// it is also empty if it was created with WithDrainOnClose.
+func (q *Fair[K, T, P]) isDone() bool {
+	return q.closed && (!q.graceful || q.isEmpty())
+}
+
+// Length returns the number of elements in the queue across all keys.
+func (q *Fair[K, T, P]) Length() (size int) {
+	q.lock.Lock()
+	size = int(q.length)
+	q.lock.Unlock()
+	return
+}
+
+// KeyLength returns the number of elements in the sub-queue of the given key.
+func (q *Fair[K, T, P]) KeyLength(key K) (size int) {
+	q.lock.Lock()
+	if f, ok := q.flows[key]; ok {
+		size = f.ring.length()
+	}
+	q.lock.Unlock()
+	return
+}
+
+// Close closes the queue permanently.
+//
+// The Drain method can be used to drain the queue after it is closed.
+func (q *Fair[K, T, P]) Close() {
+	q.lock.Lock()
+	q.closed = true
+	for _, f := range q.flows {
+		f.notFull.Broadcast()
+	}
+	q.notEmpty.Broadcast()
+	q.lock.Unlock()
+}
+
+// TryPush adds an element to the sub-queue of the given key without
+// blocking, returning FullError if the sub-queue is full.
+func (q *Fair[K, T, P]) TryPush(key K, p P) error {
+	q.lock.Lock()
+	if q.closed {
+		q.lock.Unlock()
+		return Closed
+	}
+	f := q.flow(key)
+	if uint64(f.ring.length()) >= q.keySize {
+		q.lock.Unlock()
+		return FullError
+	}
+	q.push(f, p)
+	q.lock.Unlock()
+	return nil
+}
+
+// Push adds an element to the sub-queue of the given key,
+// blocking until there is space in the sub-queue.
+func (q *Fair[K, T, P]) Push(key K, p P) error {
+	return q.PushContext(context.Background(), key, p)
+}
+
+// PushContext adds an element to the sub-queue of the given key, blocking until
+// there is space in the sub-queue.
+//
+// If the context is cancelled while waiting, ctx.Err() is returned and the
+// element is not added to the queue.
+func (q *Fair[K, T, P]) PushContext(ctx context.Context, key K, p P) error {
+	var stop func()
+	var waited time.Time
+	q.lock.Lock()
+	f := q.flow(key)
+	f.waiters++
+LOOP:
+	if q.closed {
+		f.waiters--
+		q.release(f)
+		q.lock.Unlock()
+		q.stats.pushWaited(waited)
+		if stop != nil {
+			stop()
+		}
+		return Closed
+	}
+	if uint64(f.ring.length()) >= q.keySize {
+		if err := ctx.Err(); err != nil {
+			f.waiters--
+			q.release(f)
+			q.lock.Unlock()
+			q.stats.pushWaited(waited)
+			if stop != nil {
+				stop()
+			}
+			return err
+		}
+		if stop == nil {
+			stop = wakeOnDone(ctx, f.notFull)
+		}
+		if waited.IsZero() {
+			waited = q.stats.now()
+		}
+		f.notFull.Wait()
+		goto LOOP
+	}
+
+	f.waiters--
+	q.push(f, p)
+	q.lock.Unlock()
+	q.stats.pushWaited(waited)
+	if stop != nil {
+		stop()
+	}
+	return nil
+}
+
+// push is an internal function used to add an element to the given sub-queue,
+// making the key active if its sub-queue was empty.
+func (q *Fair[K, T, P]) push(f *flow[K, T, P], p P) {
+	if f.ring.length() == 0 {
+		q.active.push(f)
+	}
+	f.ring.push(p)
+	q.length++
+	q.stats.push(1)
+	q.notEmpty.Signal()
+}
+
+// Pop removes the next element from the queue in weighted
+// round-robin order, blocking until one is available.
+func (q *Fair[K, T, P]) Pop() (P, error) {
+	return q.PopContext(context.Background())
+}
+
+// PopContext removes the next element from the queue in weighted round-robin
+// order, blocking until one is available.
+//
+// If the context is cancelled while waiting, ctx.Err() is returned.
+func (q *Fair[K, T, P]) PopContext(ctx context.Context) (p P, err error) {
+	var stop func()
+	var waited time.Time
+	q.lock.Lock()
+LOOP:
+	if q.isDone() {
+		q.lock.Unlock()
+		q.stats.popWaited(waited)
+		if stop != nil {
+			stop()
+		}
+		return nil, Closed
+	}
+	if q.isEmpty() {
+		if err = ctx.Err(); err != nil {
+			q.lock.Unlock()
+			q.stats.popWaited(waited)
+			if stop != nil {
+				stop()
+			}
+			return nil, err
+		}
+		if stop == nil {
+			stop = wakeOnDone(ctx, q.notEmpty)
+		}
+		if waited.IsZero() {
+			waited = q.stats.now()
+		}
+		q.notEmpty.Wait()
+		goto LOOP
+	}
+
+	p = q.pop()
+	q.lock.Unlock()
+	q.stats.popWaited(waited)
+	if stop != nil {
+		stop()
+	}
+	return
+}
+
+// pop is an internal function used to remove the next element from the
+// sub-queue whose turn it is, the queue must not be empty.
+//
+// This is deficit round-robin where every element costs 1: a key that starts its
+// turn gets a deficit equal to its weight, and keeps its turn until the deficit
+// is used up or its sub-queue is empty.
+func (q *Fair[K, T, P]) pop() P {
+	f := q.active.peek()
+	if f.deficit == 0 {
+		f.deficit = f.weight
+	}
+	p := f.ring.pop()
+	f.deficit--
+	q.length--
+	q.stats.pop(1)
+	f.notFull.Signal()
+	if f.ring.length() == 0 {
+		q.active.pop()
+		f.deficit = 0
+		q.release(f)
+	} else if f.deficit == 0 {
+		q.active.push(q.active.pop())
+	}
+	return p
+}
+
+// Drain removes all elements from the queue
+// and returns them in a slice, in weighted round-robin order.
+//
+// This function should only be called after the queue is closed.
+func (q *Fair[K, T, P]) Drain() (values []P) {
+	q.lock.Lock()
+	if q.isEmpty() {
+		q.lock.Unlock()
+		return nil
+	}
+	values = make([]P, 0, q.length)
+	for !q.isEmpty() {
+		values = append(values, q.pop())
+	}
+	q.lock.Unlock()
+	return values
+}
@@ -0,0 +1,167 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFair(t *testing.T) {
+	t.Parallel()
+
+	testPacket := func(i int, s string) *P {
+		return &P{Int: i, String: s}
+	}
+
+	t.Run("round robin", func(t *testing.T) {
+		rb := NewFair[string, P, *P](8)
+		for i := 0; i < 4; i++ {
+			require.NoError(t, rb.Push("a", testPacket(i, "a")))
+		}
+		require.NoError(t, rb.Push("b", testPacket(0, "b")))
+		require.NoError(t, rb.Push("b", testPacket(1, "b")))
+		require.NoError(t, rb.Push("c", testPacket(0, "c")))
+		assert.Equal(t, 7, rb.Length())
+		assert.Equal(t, 4, rb.KeyLength("a"))
+
+		expected := []string{"a0", "b0", "c0", "a1", "b1", "a2", "a3"}
+		for _, e := range expected {
+			p, err := rb.Pop()
+			require.NoError(t, err)
+			assert.Equal(t, e, p.String+string(rune('0'+p.Int)))
+		}
+		assert.True(t, rb.IsEmpty())
+		assert.Empty(t, rb.flows)
+	})
+	t.Run("weighted", func(t *testing.T) {
+		rb := NewFair[string, P, *P](8)
+		rb.SetWeight("a", 3)
+		for i := 0; i < 4; i++ {
+			require.NoError(t, rb.Push("a", testPacket(i, "a")))
+			require.NoError(t, rb.Push("b", testPacket(i, "b")))
+		}
+
+		var actual string
+		for i := 0; i < 8; i++ {
+			p, err := rb.Pop()
+			require.NoError(t, err)
+			actual += p.String
+		}
+		assert.Equal(t, "aaababbb", actual)
+
+		rb.SetWeight("a", 0)
+		for i := 0; i < 2; i++ {
+			require.NoError(t, rb.Push("a", testPacket(i, "a")))
+			require.NoError(t, rb.Push("b", testPacket(i, "b")))
+		}
+		actual = ""
+		for i := 0; i < 4; i++ {
+			p, err := rb.Pop()
+			require.NoError(t, err)
+			actual += p.String
+		}
+		assert.Equal(t, "abab", actual)
+	})
+	t.Run("key capacity", func(t *testing.T) {
+		rb := NewFair[string, P, *P](2)
+		require.NoError(t, rb.TryPush("a", testPacket(0, "a")))
+		require.NoError(t, rb.TryPush("a", testPacket(1, "a")))
+		assert.ErrorIs(t, rb.TryPush("a", testPacket(2, "a")), FullError)
+		require.NoError(t, rb.TryPush("b", testPacket(0, "b")))
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+		defer cancel()
+		assert.ErrorIs(t, rb.PushContext(ctx, "a", testPacket(2, "a")), context.DeadlineExceeded)
+
+		doneCh := make(chan error, 1)
+		go func() {
+			doneCh <- rb.Push("a", testPacket(2, "a"))
+		}()
+		require.NoError(t, rb.Push("b", testPacket(1, "b")))
+		select {
+		case <-doneCh:
+			t.Fatal("Fair did not block on full key")
+		case <-time.After(time.Millisecond * 10):
+		}
+
+		p, err := rb.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, "a", p.String)
+		select {
+		case err = <-doneCh:
+			require.NoError(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("Fair did not unblock on pop")
+		}
+		assert.Equal(t, 2, rb.KeyLength("a"))
+		assert.Equal(t, 2, rb.KeyLength("b"))
+	})
+	t.Run("pop blocks until push", func(t *testing.T) {
+		rb := NewFair[int, P, *P](1)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+		defer cancel()
+		_, err := rb.PopContext(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+		doneCh := make(chan *P, 1)
+		go func() {
+			p, err := rb.Pop()
+			assert.NoError(t, err)
+			doneCh <- p
+		}()
+		time.Sleep(time.Millisecond * 10)
+		require.NoError(t, rb.Push(1, testPacket(1, "")))
+		select {
+		case p := <-doneCh:
+			assert.Equal(t, 1, p.Int)
+		case <-time.After(time.Second):
+			t.Fatal("Fair did not unblock on push")
+		}
+	})
+	t.Run("close", func(t *testing.T) {
+		rb := NewFair[string, P, *P](1)
+		require.NoError(t, rb.Push("a", testPacket(0, "a")))
+		require.NoError(t, rb.Push("b", testPacket(0, "b")))
+
+		doneCh := make(chan error, 1)
+		go func() {
+			doneCh <- rb.Push("a", testPacket(1, "a"))
+		}()
+		time.Sleep(time.Millisecond * 10)
+		rb.Close()
+		select {
+		case err := <-doneCh:
+			assert.ErrorIs(t, err, Closed)
+		case <-time.After(time.Second):
+			t.Fatal("Fair did not unblock push on close")
+		}
+		assert.True(t, rb.IsClosed())
+		assert.ErrorIs(t, rb.Push("c", testPacket(0, "c")), Closed)
+		_, err := rb.Pop()
+		assert.ErrorIs(t, err, Closed)
+
+		values := rb.Drain()
+		require.Len(t, values, 2)
+		assert.Equal(t, "a", values[0].String)
+		assert.Equal(t, "b", values[1].String)
+		assert.Nil(t, rb.Drain())
+	})
+	t.Run("drain on close", func(t *testing.T) {
+		rb := NewFair[string, P, *P](2, WithDrainOnClose[P, *P]())
+		require.NoError(t, rb.Push("a", testPacket(0, "a")))
+		require.NoError(t, rb.Push("b", testPacket(0, "b")))
+		rb.Close()
+		for _, e := range []string{"a", "b"} {
+			p, err := rb.Pop()
+			require.NoError(t, err)
+			assert.Equal(t, e, p.String)
+		}
+		_, err := rb.Pop()
+		assert.ErrorIs(t, err, Closed)
+	})
+}
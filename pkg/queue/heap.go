@@ -0,0 +1,108 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"time"
+)
+
+// entry is an element stored in a heap, along with the order
+// in which it was pushed and an optional deadline.
+type entry[T any, P Pointer[T]] struct {
+	value    P
+	deadline time.Time
+	sequence uint64
+}
+
+// heap is a binary min-heap of entries that is used by the Priority and Delay
+// queues. Entries that are equal according to the less function are popped in
+// the order in which they were pushed.
+//
+// It is not thread safe, and must be protected by the lock of the queue using it.
+type heap[T any, P Pointer[T]] struct {
+	less     func(a *entry[T, P], b *entry[T, P]) bool
+	sequence uint64
+	nodes    []entry[T, P]
+}
+
+// newHeap creates a new heap that pops the entry a before the
+// entry b if less(a, b) returns true.
+func newHeap[T any, P Pointer[T]](less func(a *entry[T, P], b *entry[T, P]) bool) *heap[T, P] {
+	return &heap[T, P]{
+		less: less,
+	}
+}
+
+// length returns the number of entries in the heap.
+func (h *heap[T, P]) length() int {
+	return len(h.nodes)
+}
+
+// peek returns the entry at the top of the heap without removing it,
+// the heap must not be empty.
+func (h *heap[T, P]) peek() *entry[T, P] {
+	return &h.nodes[0]
+}
+
+// push adds an entry to the heap.
+func (h *heap[T, P]) push(e entry[T, P]) {
+	e.sequence = h.sequence
+	h.sequence++
+	h.nodes = append(h.nodes, e)
+	h.up(len(h.nodes) - 1)
+}
+
+// pop removes the entry at the top of the heap, the heap must not be empty.
+func (h *heap[T, P]) pop() (e entry[T, P]) {
+	last := len(h.nodes) - 1
+	e = h.nodes[0]
+	h.nodes[0] = h.nodes[last]
+	h.nodes[last] = entry[T, P]{}
+	h.nodes = h.nodes[:last]
+	if last > 0 {
+		h.down(0)
+	}
+	return
+}
+
+// before returns true if the entry at index i should be
+// popped before the entry at index j.
+func (h *heap[T, P]) before(i int, j int) bool {
+	if h.less(&h.nodes[i], &h.nodes[j]) {
+		return true
+	}
+	if h.less(&h.nodes[j], &h.nodes[i]) {
+		return false
+	}
+	return h.nodes[i].sequence < h.nodes[j].sequence
+}
+
+// up moves the entry at index i up the heap until the heap property is restored.
+func (h *heap[T, P]) up(i int) {
+	for i > 0 {
+		parent := (i - 1) / 2
+		if !h.before(i, parent) {
+			return
+		}
+		h.nodes[i], h.nodes[parent] = h.nodes[parent], h.nodes[i]
+		i = parent
+	}
+}
+
+// down moves the entry at index i down the heap until the heap property is restored.
+func (h *heap[T, P]) down(i int) {
+	for {
+		child := 2*i + 1
+		if child >= len(h.nodes) {
+			return
+		}
+		if right := child + 1; right < len(h.nodes) && h.before(right, child) {
+			child = right
+		}
+		if !h.before(child, i) {
+			return
+		}
+		h.nodes[i], h.nodes[child] = h.nodes[child], h.nodes[i]
+		i = child
+	}
+}
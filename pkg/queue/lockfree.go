@@ -3,8 +3,11 @@
 package queue
 
 import (
+	"context"
 	"runtime"
 	"sync/atomic"
+	"time"
+	"unsafe"
 )
 
 type Pointer[T any] interface {
@@ -12,11 +15,24 @@ type Pointer[T any] interface {
 }
 
 // node is a struct that keeps track of its own position as well as a piece of data.
+//
+// The data is only ever accessed atomically, so that it can be read by Peek
+// operations while a consumer is removing it.
 type node[T any, P Pointer[T]] struct {
 	_padding0 [8]uint64 //nolint:structcheck,unused
 	position  uint64
 	_padding1 [8]uint64 //nolint:structcheck,unused
-	data      P
+	data      unsafe.Pointer
+}
+
+// load returns the data stored in the node.
+func (n *node[T, P]) load() P {
+	return P(atomic.LoadPointer(&n.data))
+}
+
+// store stores the given data in the node.
+func (n *node[T, P]) store(p P) {
+	atomic.StorePointer(&n.data, unsafe.Pointer(p))
 }
 
 // nodes is a struct type containing a slice of node pointers
@@ -24,38 +40,73 @@ type nodes[T any, P Pointer[T]] []*node[T, P]
 
 // LockFree is the struct used to store a blocking or non-blocking FIFO queue of type *packet.Packet
 //
-// In it's non-blocking form it acts as a ringbuffer, overwriting old data when new data arrives. In its blocking
-// form it waits for a space in the queue to open up before it adds the item to the LockFree.
+// In it's non-blocking form (see WithOverwrite) it acts as a ringbuffer, overwriting old data when new data arrives.
+// In its blocking form it waits for a space in the queue to open up before it adds the item to the LockFree.
 type LockFree[T any, P Pointer[T]] struct {
-	_padding0 [8]uint64 //nolint:structcheck,unused
-	head      uint64
-	_padding1 [8]uint64 //nolint:structcheck,unused
-	tail      uint64
-	_padding2 [8]uint64 //nolint:structcheck,unused
-	mask      uint64
-	_padding3 [8]uint64 //nolint:structcheck,unused
-	closed    uint64
-	_padding4 [8]uint64 //nolint:structcheck,unused
-	nodes     []*node[T, P]
-	_padding5 [8]uint64 //nolint:structcheck,unused
-	overflow  func() (uint64, error)
+	_padding0  [8]uint64 //nolint:structcheck,unused
+	head       uint64
+	_padding1  [8]uint64 //nolint:structcheck,unused
+	tail       uint64
+	_padding2  [8]uint64 //nolint:structcheck,unused
+	mask       uint64
+	_padding3  [8]uint64 //nolint:structcheck,unused
+	capacity   uint64
+	_padding4  [8]uint64 //nolint:structcheck,unused
+	closed     uint64
+	_padding5  [8]uint64 //nolint:structcheck,unused
+	nodes      []*node[T, P]
+	_padding6  [8]uint64 //nolint:structcheck,unused
+	overflow   func(context.Context, uint64) (uint64, error)
+	_padding7  [8]uint64 //nolint:structcheck,unused
+	evicted    func(P)
+	_padding8  [8]uint64 //nolint:structcheck,unused
+	wait       WaitStrategy
+	_padding9  [8]uint64 //nolint:structcheck,unused
+	graceful   bool
+	_padding10 [8]uint64 //nolint:structcheck,unused
+	stats      *Stats
+	_padding11 [8]uint64 //nolint:structcheck,unused
+	pushers    uint64
+	_padding12 [8]uint64 //nolint:structcheck,unused
+	poppers    uint64
 }
 
 // NewLockFree creates a new LockFree with blocking or non-blocking behavior
-func NewLockFree[T any, P Pointer[T]](size uint64) *LockFree[T, P] {
+//
+// By default the LockFree is blocking, the WithOverwrite option can be used
+// to make it non-blocking. The WithDrainOnClose option can be used to keep
+// returning the remaining items from Pop after the LockFree is closed.
+func NewLockFree[T any, P Pointer[T]](size uint64, opts ...Option[T, P]) *LockFree[T, P] {
 	q := new(LockFree[T, P])
 	if size < 1 {
 		size = 1
 	}
-	q.overflow = q.blocker
+	o := newOptions(opts)
+	q.wait = o.wait
+	q.graceful = o.graceful
+	q.stats = o.stats
+	if o.overwrite {
+		q.evicted = o.evicted
+		q.overflow = q.overwriter
+	} else {
+		q.overflow = q.blocker
+	}
 	q.init(size)
 	return q
 }
 
 // init actually initializes a queue and can be used in the future to reuse LockFree structs
 // with their own pool
+//
+// At least two slots are always allocated, since a slot's position cannot distinguish
+// between being freed and being filled when there is only one of them. The capacity is
+// enforced separately by comparing the head and tail positions.
 func (q *LockFree[T, P]) init(size uint64) {
 	size = round(size)
+	q.capacity = size
+	if size < 2 {
+		size = 2
+	}
 	q.nodes = make(nodes[T, P], size)
 	for i := uint64(0); i < size; i++ {
 		q.nodes[i] = &node[T, P]{position: i}
@@ -64,63 +115,107 @@ func (q *LockFree[T, P]) init(size uint64) {
 }
 
 // blocker is a LockFree.overflow function that blocks a Push operation from
-// proceeding if the LockFree is ever full of data.
-//
-// If two Push operations happen simultaneously, blocker will block both of them until
-// a Pop takes place, and unblock both of them at the same time. This can cause problems,
-// however in our use case it won't because there shouldn't ever be more than one producer
-// operating on the LockFree at any given time. There may be multiple consumers in the future,
-// but that won't cause any problems.
-//
-// If we decide to use this as an MPMC LockFree instead of a SPMC LockFree (which is how we currently use it)
-// then we can solve this bug by replacing the existing `default` switch case in the Push function with the
-// following snippet:
-// ```
-// default:
-//
-//	head, err = q.overflow()
-//	if err != nil {
-//		return err
-//	}
-//
-// ```
-func (q *LockFree[T, P]) blocker() (head uint64, err error) {
-LOOP:
-	head = atomic.LoadUint64(&q.head)
-	if uint64(len(q.nodes)) == head-atomic.LoadUint64(&q.tail) {
+// proceeding while the LockFree is at capacity or the slot at the given head position
+// is still occupied.
+//
+// It returns the most recent head position once the slot has been freed by a Pop
+// or another producer has claimed the position, so that the caller can retry.
+func (q *LockFree[T, P]) blocker(ctx context.Context, head uint64) (uint64, error) {
+	var stop func()
+	var waited time.Time
+	var registered bool
+	defer func() {
+		if registered {
+			atomic.AddUint64(&q.pushers, ^uint64(0))
+		}
+		q.stats.pushWaited(waited)
+		if stop != nil {
+			stop()
+		}
+	}()
+	var iteration uint64
+	for {
+		epoch := q.wait.Epoch()
 		if atomic.LoadUint64(&q.closed) == 1 {
-			err = Closed
-			return
+			return 0, Closed
+		}
+		newHead := atomic.LoadUint64(&q.head)
+		if newHead != head || (head-atomic.LoadUint64(&q.tail) < q.capacity &&
+			atomic.LoadUint64(&q.nodes[head&q.mask].position) == head) {
+			return newHead, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return 0, err
 		}
+		if !registered {
+			registered = true
+			atomic.AddUint64(&q.pushers, 1)
+			continue
+		}
+		if stop == nil {
+			stop = notifyOnDone(ctx, q.wait)
+		}
+		if waited.IsZero() {
+			waited = q.stats.now()
+		}
+		q.wait.Wait(iteration, epoch)
+		iteration++
+	}
+}
+
+// notify is an internal function used to notify the WaitStrategy after items were
+// added (with the poppers counter) or removed (with the pushers counter), and only
+// does so if an operation registered in the counter may be waiting for them.
+//
+// Waiting operations register themselves before they check the LockFree one last
+// time, so either they see the change or the change sees them, and when nobody is
+// waiting Push and Pop never touch the WaitStrategy.
+func (q *LockFree[T, P]) notify(waiters *uint64) {
+	if atomic.LoadUint64(waiters) > 0 {
+		q.wait.Notify()
+	}
+}
+
+// overwriter is a LockFree.overflow function that makes room for a Push operation
+// by evicting the oldest item in the LockFree, passing it to the evicted callback if
+// one was configured.
+//
+// It returns the most recent head position so that the caller can retry. If another
+// Pop or overwriter evicted the oldest item first, nothing is evicted and the
+// caller simply retries.
+func (q *LockFree[T, P]) overwriter(context.Context, uint64) (uint64, error) {
+	if atomic.LoadUint64(&q.closed) == 1 {
+		return 0, Closed
+	}
+	if item, ok := q.tryPop(); ok {
+		q.stats.evict(1)
+		if q.evicted != nil {
+			q.evicted(item)
+		}
+	} else {
 		runtime.Gosched()
-		goto LOOP
 	}
-	return
+	return atomic.LoadUint64(&q.head), nil
 }
 
 // Push appends an item of type *packet.Packet to the LockFree, and will block
-// until the item is pushed succ›essfully (with the blocking function depending
+// until the item is pushed successfully (with the blocking function depending
 // on whether this is a blocking LockFree).
 //
-// This method is not meant to be used concurrently, and the LockFree is meant to operate
-// as an SPMC LockFree with one producer operating at a time. If we want to use this as an MPMC LockFree
-// we can modify this Push function by replacing the existing `default` switch case with the
-// following snippet:
-// ```
-// default:
-//
-//	head, err = q.overflow()
-//	if err != nil {
-//		return err
-//	}
-//
-// ```
+// This method is safe to be used concurrently by multiple producers. Each producer
+// claims a position by advancing the head with a CAS, and only writes to the slot once
+// that slot has been released by the consumer of the previous lap, so concurrent Push
+// calls never overwrite each other's data.
 func (q *LockFree[T, P]) Push(item P) error {
+	return q.PushContext(context.Background(), item)
+}
+
+// PushContext appends an item to the LockFree the same way Push does, however if the
+// LockFree is full and the context is cancelled while waiting for space, ctx.Err() is
+// returned and the item is not pushed.
+func (q *LockFree[T, P]) PushContext(ctx context.Context, item P) (err error) {
 	var newNode *node[T, P]
-	head, err := q.overflow()
-	if err != nil {
-		return err
-	}
+	head := atomic.LoadUint64(&q.head)
 RETRY:
 	for {
 		if atomic.LoadUint64(&q.closed) == 1 {
@@ -128,22 +223,86 @@ RETRY:
 		}
 
 		newNode = q.nodes[head&q.mask]
-		switch dif := atomic.LoadUint64(&newNode.position) - head; {
-		case dif == 0:
+		switch dif := int64(atomic.LoadUint64(&newNode.position) - head); {
+		case dif == 0 && head-atomic.LoadUint64(&q.tail) < q.capacity:
 			if atomic.CompareAndSwapUint64(&q.head, head, head+1) {
 				break RETRY
 			}
+			head = atomic.LoadUint64(&q.head)
+		case dif <= 0:
+			head, err = q.overflow(ctx, head)
+			if err != nil {
+				return err
+			}
+			continue
 		default:
 			head = atomic.LoadUint64(&q.head)
 		}
 		runtime.Gosched()
 	}
-	// TODO: detected race condition here and on line 174
-	newNode.data = item
+	newNode.store(item)
 	atomic.StoreUint64(&newNode.position, head+1)
+	q.stats.push(1)
+	q.notify(&q.poppers)
 	return nil
 }
 
+// PushBatch appends all the given items to the LockFree in order, returning the number
+// of items that were pushed.
+//
+// As many contiguous free slots as are available are claimed with a single CAS, and when
+// the LockFree is full PushBatch blocks (or evicts old items) the same way Push does.
+// Items from a batch that had to wait may be interleaved with items from other producers.
+func (q *LockFree[T, P]) PushBatch(items []P) (n int, err error) {
+	for n < len(items) {
+		if atomic.LoadUint64(&q.closed) == 1 {
+			return n, Closed
+		}
+
+		head := atomic.LoadUint64(&q.head)
+		count := q.free(head, uint64(len(items)-n))
+		if count == 0 {
+			if int64(atomic.LoadUint64(&q.nodes[head&q.mask].position)-head) <= 0 {
+				if _, err = q.overflow(context.Background(), head); err != nil {
+					return n, err
+				}
+				continue
+			}
+			runtime.Gosched()
+			continue
+		}
+		if !atomic.CompareAndSwapUint64(&q.head, head, head+count) {
+			runtime.Gosched()
+			continue
+		}
+		for i := uint64(0); i < count; i++ {
+			newNode := q.nodes[(head+i)&q.mask]
+			newNode.store(items[n])
+			atomic.StoreUint64(&newNode.position, head+i+1)
+			n++
+		}
+		q.stats.push(int(count))
+		q.notify(&q.poppers)
+	}
+	return n, nil
+}
+
+// free returns the number of contiguous free slots starting at the given head
+// position, up to max.
+func (q *LockFree[T, P]) free(head uint64, max uint64) (count uint64) {
+	used := head - atomic.LoadUint64(&q.tail)
+	if used >= q.capacity {
+		return 0
+	}
+	if available := q.capacity - used; available < max {
+		max = available
+	}
+	for count < max && atomic.LoadUint64(&q.nodes[(head+count)&q.mask].position) == head+count {
+		count++
+	}
+	return
+}
+
 // Pop removes an item from the start of the LockFree and returns it to the caller.
 // This method blocks until an item is available, but unblocks when the LockFree is closed.
 // This allows for long-term listeners to wait on the LockFree until either an item is available
@@ -151,35 +310,234 @@ RETRY:
 //
 // This method is safe to be used concurrently and is even optimized for the SPMC use case.
 func (q *LockFree[T, P]) Pop() (P, error) {
+	return q.PopContext(context.Background())
+}
+
+// PopContext removes an item from the start of the LockFree the same way Pop does,
+// however if the context is cancelled while waiting for an item, ctx.Err() is returned.
+func (q *LockFree[T, P]) PopContext(ctx context.Context) (P, error) {
+	var stop func()
+	var waited time.Time
+	var registered bool
+	defer func() {
+		if registered {
+			atomic.AddUint64(&q.poppers, ^uint64(0))
+		}
+		q.stats.popWaited(waited)
+		if stop != nil {
+			stop()
+		}
+	}()
+	var iteration uint64
+	for {
+		epoch := q.wait.Epoch()
+		if q.isDone() {
+			return nil, Closed
+		}
+		if item, ok := q.tryPop(); ok {
+			q.stats.pop(1)
+			q.notify(&q.pushers)
+			return item, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if !registered {
+			registered = true
+			atomic.AddUint64(&q.poppers, 1)
+			continue
+		}
+		if stop == nil {
+			stop = notifyOnDone(ctx, q.wait)
+		}
+		if waited.IsZero() {
+			waited = q.stats.now()
+		}
+		q.wait.Wait(iteration, epoch)
+		iteration++
+	}
+}
+
+// PopBatch removes up to max items (and at most len(dst)) from the start of the LockFree
+// and stores them in dst, returning the number of items that were removed.
+//
+// It blocks until at least one item is available, and then claims as many contiguous
+// items as are available with a single CAS.
+func (q *LockFree[T, P]) PopBatch(dst []P, max int) (int, error) {
+	if max > len(dst) {
+		max = len(dst)
+	}
+	if max <= 0 {
+		return 0, nil
+	}
+	var waited time.Time
+	var registered bool
+	defer func() {
+		if registered {
+			atomic.AddUint64(&q.poppers, ^uint64(0))
+		}
+		q.stats.popWaited(waited)
+	}()
+	var iteration uint64
+	for {
+		epoch := q.wait.Epoch()
+		if q.isDone() {
+			return 0, Closed
+		}
+		if n := q.tryPopBatch(dst[:max]); n > 0 {
+			q.stats.pop(n)
+			q.notify(&q.pushers)
+			return n, nil
+		}
+		if !registered {
+			registered = true
+			atomic.AddUint64(&q.poppers, 1)
+			continue
+		}
+		if waited.IsZero() {
+			waited = q.stats.now()
+		}
+		q.wait.Wait(iteration, epoch)
+		iteration++
+	}
+}
+
+// tryPopBatch removes as many contiguous items (up to len(dst)) from the start of
+// the LockFree as are available, without blocking.
+func (q *LockFree[T, P]) tryPopBatch(dst []P) int {
+	for {
+		oldPosition := atomic.LoadUint64(&q.tail)
+		count := uint64(0)
+		for count < uint64(len(dst)) && atomic.LoadUint64(&q.nodes[(oldPosition+count)&q.mask].position) == oldPosition+count+1 {
+			count++
+		}
+		if count == 0 {
+			if int64(atomic.LoadUint64(&q.nodes[oldPosition&q.mask].position)-(oldPosition+1)) < 0 {
+				return 0
+			}
+			continue
+		}
+		if atomic.CompareAndSwapUint64(&q.tail, oldPosition, oldPosition+count) {
+			for i := uint64(0); i < count; i++ {
+				oldNode := q.nodes[(oldPosition+i)&q.mask]
+				dst[i] = oldNode.load()
+				oldNode.store(nil)
+				atomic.StoreUint64(&oldNode.position, oldPosition+i+q.mask+1)
+			}
+			return int(count)
+		}
+	}
+}
+
+// tryPop removes an item from the start of the LockFree if one is available,
+// without blocking.
+func (q *LockFree[T, P]) tryPop() (P, bool) {
 	var oldNode *node[T, P]
 	var oldPosition = atomic.LoadUint64(&q.tail)
-RETRY:
-	if atomic.LoadUint64(&q.closed) == 1 {
-		return nil, Closed
+	for {
+		oldNode = q.nodes[oldPosition&q.mask]
+		switch dif := int64(atomic.LoadUint64(&oldNode.position) - (oldPosition + 1)); {
+		case dif == 0:
+			if atomic.CompareAndSwapUint64(&q.tail, oldPosition, oldPosition+1) {
+				data := oldNode.load()
+				oldNode.store(nil)
+				atomic.StoreUint64(&oldNode.position, oldPosition+q.mask+1)
+				return data, true
+			}
+			oldPosition = atomic.LoadUint64(&q.tail)
+		case dif < 0:
+			return nil, false
+		default:
+			oldPosition = atomic.LoadUint64(&q.tail)
+		}
 	}
+}
 
-	oldNode = q.nodes[oldPosition&q.mask]
-	switch dif := atomic.LoadUint64(&oldNode.position) - (oldPosition + 1); {
-	case dif == 0:
-		if atomic.CompareAndSwapUint64(&q.tail, oldPosition, oldPosition+1) {
-			goto DONE
+// Peek returns the item at the start of the LockFree without removing it.
+//
+// If the LockFree is empty, EmptyError is returned. Peek never blocks and is safe to
+// use concurrently with Push and Pop, however with multiple consumers the returned item
+// may already have been removed by another consumer by the time Peek returns, so it is
+// only guaranteed to have been at the start of the LockFree at some point during the call.
+func (q *LockFree[T, P]) Peek() (P, error) {
+	for {
+		if q.isDone() {
+			return nil, Closed
+		}
+		tail := atomic.LoadUint64(&q.tail)
+		n := q.nodes[tail&q.mask]
+		if atomic.LoadUint64(&n.position) != tail+1 {
+			if atomic.LoadUint64(&q.tail) == tail {
+				return nil, EmptyError
+			}
+			continue
+		}
+		p := n.load()
+		if atomic.LoadUint64(&n.position) == tail+1 && atomic.LoadUint64(&q.tail) == tail {
+			return p, nil
+		}
+	}
+}
+
+// PeekN stores up to len(dst) items from the start of the LockFree in dst, in order,
+// without removing them, and returns the number of items that were stored. Like Peek,
+// it returns 0 once the LockFree is closed, or once it is also empty if it was created
+// with WithDrainOnClose.
+//
+// The items are read one slot at a time, and the read is retried if a Pop removes an
+// item in the meantime, so the items were all at the start of the LockFree at the same
+// time during the call, in the order they are returned. The snapshot is not linearizable
+// with respect to concurrent Push calls though: it ends at the first slot whose Push has
+// not finished yet, so it may hold fewer items than Length reported at any point during
+// the call, and it may miss items pushed into later slots before PeekN was called. With
+// multiple consumers some of the items may also already have been removed by the time
+// PeekN returns, and a steady stream of Pop calls can make it retry indefinitely.
+func (q *LockFree[T, P]) PeekN(dst []P) int {
+	for {
+		if q.isDone() {
+			return 0
+		}
+		tail := atomic.LoadUint64(&q.tail)
+		count := 0
+		for count < len(dst) {
+			n := q.nodes[(tail+uint64(count))&q.mask]
+			if atomic.LoadUint64(&n.position) != tail+uint64(count)+1 {
+				break
+			}
+			dst[count] = n.load()
+			count++
+		}
+		if atomic.LoadUint64(&q.tail) == tail {
+			return count
 		}
-	default:
-		oldPosition = atomic.LoadUint64(&q.tail)
 	}
-	runtime.Gosched()
-	goto RETRY
-DONE:
-	data := oldNode.data
-	oldNode.data = nil
-	atomic.StoreUint64(&oldNode.position, oldPosition+q.mask+1)
-	return data, nil
 }
 
 // Close marks the LockFree as closed, returns any waiting Pop() calls,
 // and blocks all future Push calls from occurring.
 func (q *LockFree[T, P]) Close() {
-	atomic.CompareAndSwapUint64(&q.closed, 0, 1)
+	if atomic.CompareAndSwapUint64(&q.closed, 0, 1) {
+		q.wait.Notify()
+	}
+}
+
+// isDone returns whether Pop operations should return Closed, which is as soon as the
+// LockFree is closed, or once it is also empty if it was created with WithDrainOnClose.
+//
+// Items that were claimed by a Push before the LockFree was closed but are not yet
+// visible count towards the length, so they are still returned in the latter case.
+func (q *LockFree[T, P]) isDone() bool {
+	return atomic.LoadUint64(&q.closed) == 1 && (!q.graceful || q.Length() == 0)
+}
+
+// IsEmpty returns whether the LockFree is currently empty
+func (q *LockFree[T, P]) IsEmpty() bool {
+	return q.Length() == 0
+}
+
+// IsFull returns whether the LockFree is currently full
+func (q *LockFree[T, P]) IsFull() bool {
+	return uint64(q.Length()) >= q.capacity
 }
 
 // IsClosed returns whether the LockFree has been closed
@@ -188,39 +546,31 @@ func (q *LockFree[T, P]) IsClosed() bool {
 }
 
 // Length is the current number of items in the LockFree
+//
+// The tail is loaded before the head, so that a concurrent Pop can never make the
+// length appear negative.
 func (q *LockFree[T, P]) Length() int {
-	return int(atomic.LoadUint64(&q.head) - atomic.LoadUint64(&q.tail))
+	tail := atomic.LoadUint64(&q.tail)
+	return int(atomic.LoadUint64(&q.head) - tail)
 }
 
 // Drain drains all the current packets in the queue and returns them to the caller.
 //
-// It is an unsafe function that should only be used once, only after the queue has been closed,
-// and only while there are no producers writing to it. If used incorrectly it has the potential
-// to infinitely block the caller. If used correctly, it allows a single caller to drain any remaining
-// packets in the queue after the queue has been closed.
+// It never blocks, and is safe to call at any time, however it is meant to be used after
+// the queue has been closed. Items that are still being pushed by a concurrent producer
+// when Drain reaches them are not returned.
 func (q *LockFree[T, P]) Drain() []P {
-	length := q.Length()
-	packets := make([]P, 0, length)
-	for i := 0; i < length; i++ {
-		var oldNode *node[T, P]
-		var oldPosition = atomic.LoadUint64(&q.tail)
-	RETRY:
-		oldNode = q.nodes[oldPosition&q.mask]
-		switch dif := atomic.LoadUint64(&oldNode.position) - (oldPosition + 1); {
-		case dif == 0:
-			if atomic.CompareAndSwapUint64(&q.tail, oldPosition, oldPosition+1) {
-				goto DONE
-			}
-		default:
-			oldPosition = atomic.LoadUint64(&q.tail)
+	packets := make([]P, 0, q.Length())
+	for {
+		data, ok := q.tryPop()
+		if !ok {
+			break
 		}
-		runtime.Gosched()
-		goto RETRY
-	DONE:
-		data := oldNode.data
-		oldNode.data = nil
-		atomic.StoreUint64(&oldNode.position, oldPosition+q.mask+1)
 		packets = append(packets, data)
 	}
+	if len(packets) > 0 {
+		q.stats.pop(len(packets))
+		q.notify(&q.pushers)
+	}
 	return packets
 }
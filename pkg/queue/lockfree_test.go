@@ -3,6 +3,8 @@
 package queue
 
 import (
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -145,4 +147,400 @@ func TestLockFree(t *testing.T) {
 		assert.NotEqual(t, p1, p5)
 		assert.Equal(t, 0, rb.Length())
 	})
+	t.Run("multiple producers, single consumer", func(t *testing.T) {
+		const producers = 8
+		const items = 2048
+		rb := NewLockFree[P, *P](4)
+		var wg sync.WaitGroup
+		for i := 0; i < producers; i++ {
+			wg.Add(1)
+			go func(producer int) {
+				defer wg.Done()
+				for j := 0; j < items; j++ {
+					assert.NoError(t, rb.Push(&P{Int: j, String: string(rune('a' + producer))}))
+				}
+			}(i)
+		}
+
+		next := make(map[string]int, producers)
+		for i := 0; i < producers*items; i++ {
+			actual, err := rb.Pop()
+			require.NoError(t, err)
+			require.NotNil(t, actual)
+			require.Equalf(t, next[actual.String], actual.Int, "producer %s out of order", actual.String)
+			next[actual.String]++
+		}
+		wg.Wait()
+		assert.Equal(t, 0, rb.Length())
+		for i := 0; i < producers; i++ {
+			assert.Equal(t, items, next[string(rune('a'+i))])
+		}
+	})
+	t.Run("multiple producers, multiple consumers", func(t *testing.T) {
+		const producers = 8
+		const consumers = 4
+		const items = 2048
+		rb := NewLockFree[P, *P](16)
+		var producerWg sync.WaitGroup
+		for i := 0; i < producers; i++ {
+			producerWg.Add(1)
+			go func(producer int) {
+				defer producerWg.Done()
+				for j := 0; j < items; j++ {
+					assert.NoError(t, rb.Push(&P{Int: producer*items + j}))
+				}
+			}(i)
+		}
+
+		var consumerWg sync.WaitGroup
+		received := make([][]int, consumers)
+		for i := 0; i < consumers; i++ {
+			consumerWg.Add(1)
+			go func(consumer int) {
+				defer consumerWg.Done()
+				last := make(map[int]int, producers)
+				for {
+					actual, err := rb.Pop()
+					if err != nil {
+						assert.ErrorIs(t, err, Closed)
+						return
+					}
+					producer := actual.Int / items
+					if previous, ok := last[producer]; ok {
+						assert.Greater(t, actual.Int, previous)
+					}
+					last[producer] = actual.Int
+					received[consumer] = append(received[consumer], actual.Int)
+				}
+			}(i)
+		}
+
+		producerWg.Wait()
+		for rb.Length() > 0 {
+			time.Sleep(time.Millisecond)
+		}
+		rb.Close()
+		consumerWg.Wait()
+
+		seen := make([]bool, producers*items)
+		for _, r := range received {
+			for _, v := range r {
+				require.Falsef(t, seen[v], "item %d received twice", v)
+				seen[v] = true
+			}
+		}
+		for v, ok := range seen {
+			require.Truef(t, ok, "item %d was never received", v)
+		}
+	})
+	t.Run("close unblocks multiple producers", func(t *testing.T) {
+		rb := NewLockFree[P, *P](1)
+		require.NoError(t, rb.Push(testPacket()))
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.ErrorIs(t, rb.Push(testPacket2()), Closed)
+			}()
+		}
+		time.Sleep(time.Millisecond * 10)
+		rb.Close()
+		wg.Wait()
+	})
+	t.Run("overwrite", func(t *testing.T) {
+		var evicted []*P
+		rb := NewLockFree[P, *P](4, WithOverwrite[P, *P](func(p *P) {
+			evicted = append(evicted, p)
+		}))
+		packets := make([]*P, 6)
+		for i := range packets {
+			packets[i] = testPacket()
+			packets[i].Int = i
+			err := rb.Push(packets[i])
+			require.NoError(t, err)
+		}
+		assert.Equal(t, 4, rb.Length())
+		assert.Equal(t, packets[:2], evicted)
+
+		for i := 2; i < len(packets); i++ {
+			actual, err := rb.Pop()
+			require.NoError(t, err)
+			assert.Equal(t, packets[i], actual)
+		}
+		assert.Equal(t, 0, rb.Length())
+	})
+	t.Run("overwrite without callback", func(t *testing.T) {
+		rb := NewLockFree[P, *P](1, WithOverwrite[P, *P](nil))
+		p1 := testPacket()
+		p2 := testPacket2()
+		require.NoError(t, rb.Push(p1))
+		require.NoError(t, rb.Push(p2))
+		assert.Equal(t, 1, rb.Length())
+		actual, err := rb.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, p2, actual)
+	})
+	t.Run("overwrite with multiple producers", func(t *testing.T) {
+		const producers = 8
+		const items = 1024
+		var evicted uint64
+		rb := NewLockFree[P, *P](8, WithOverwrite[P, *P](func(*P) {
+			atomic.AddUint64(&evicted, 1)
+		}))
+		var wg sync.WaitGroup
+		for i := 0; i < producers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < items; j++ {
+					assert.NoError(t, rb.Push(testPacket()))
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, 8, rb.Length())
+		assert.Equal(t, uint64(producers*items-8), atomic.LoadUint64(&evicted))
+		rb.Close()
+		assert.ErrorIs(t, rb.Push(testPacket()), Closed)
+	})
+	t.Run("batch", func(t *testing.T) {
+		rb := NewLockFree[P, *P](4)
+		packets := make([]*P, 3)
+		for i := range packets {
+			packets[i] = testPacket()
+			packets[i].Int = i
+		}
+		n, err := rb.PushBatch(packets)
+		require.NoError(t, err)
+		assert.Equal(t, 3, n)
+		assert.Equal(t, 3, rb.Length())
+
+		dst := make([]*P, 8)
+		n, err = rb.PopBatch(dst, 2)
+		require.NoError(t, err)
+		assert.Equal(t, 2, n)
+		assert.Equal(t, packets[:2], dst[:n])
+
+		n, err = rb.PopBatch(dst, 8)
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+		assert.Equal(t, packets[2:], dst[:n])
+		assert.Equal(t, 0, rb.Length())
+	})
+	t.Run("batch overwrite", func(t *testing.T) {
+		var evicted []*P
+		rb := NewLockFree[P, *P](2, WithOverwrite[P, *P](func(p *P) {
+			evicted = append(evicted, p)
+		}))
+		packets := make([]*P, 5)
+		for i := range packets {
+			packets[i] = testPacket()
+			packets[i].Int = i
+		}
+		n, err := rb.PushBatch(packets)
+		require.NoError(t, err)
+		assert.Equal(t, 5, n)
+		assert.Equal(t, packets[:3], evicted)
+
+		dst := make([]*P, 4)
+		n, err = rb.PopBatch(dst, len(dst))
+		require.NoError(t, err)
+		assert.Equal(t, packets[3:], dst[:n])
+	})
+	t.Run("batch with multiple producers and consumers", func(t *testing.T) {
+		const producers = 4
+		const consumers = 4
+		const batches = 256
+		const batchSize = 5
+		rb := NewLockFree[P, *P](8)
+		var wg sync.WaitGroup
+		for i := 0; i < producers; i++ {
+			wg.Add(1)
+			go func(producer int) {
+				defer wg.Done()
+				for j := 0; j < batches; j++ {
+					batch := make([]*P, batchSize)
+					for k := range batch {
+						batch[k] = &P{Int: (producer*batches+j)*batchSize + k}
+					}
+					n, err := rb.PushBatch(batch)
+					assert.NoError(t, err)
+					assert.Equal(t, batchSize, n)
+				}
+			}(i)
+		}
+
+		results := make(chan []int, consumers)
+		for i := 0; i < consumers; i++ {
+			go func() {
+				var received []int
+				dst := make([]*P, 3)
+				for {
+					n, err := rb.PopBatch(dst, len(dst))
+					if err != nil {
+						results <- received
+						return
+					}
+					for _, p := range dst[:n] {
+						received = append(received, p.Int)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		for rb.Length() > 0 {
+			time.Sleep(time.Millisecond)
+		}
+		rb.Close()
+
+		seen := make([]bool, producers*batches*batchSize)
+		for i := 0; i < consumers; i++ {
+			for _, v := range <-results {
+				require.Falsef(t, seen[v], "item %d received twice", v)
+				seen[v] = true
+			}
+		}
+		for v, ok := range seen {
+			require.Truef(t, ok, "item %d was never received", v)
+		}
+	})
+	t.Run("drain on close", func(t *testing.T) {
+		rb := NewLockFree[P, *P](4, WithDrainOnClose[P, *P]())
+		p1 := testPacket()
+		p2 := testPacket2()
+		require.NoError(t, rb.Push(p1))
+		require.NoError(t, rb.Push(p2))
+		rb.Close()
+		assert.ErrorIs(t, rb.Push(testPacket()), Closed)
+
+		actual, err := rb.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, p1, actual)
+		actual, err = rb.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, p2, actual)
+		_, err = rb.Pop()
+		assert.ErrorIs(t, err, Closed)
+	})
+	t.Run("drain on close with concurrent consumers", func(t *testing.T) {
+		const consumers = 4
+		const items = 1024
+		rb := NewLockFree[P, *P](items, WithDrainOnClose[P, *P]())
+		for i := 0; i < items; i++ {
+			require.NoError(t, rb.Push(&P{Int: i}))
+		}
+		rb.Close()
+
+		var received uint64
+		var wg sync.WaitGroup
+		for i := 0; i < consumers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					if _, err := rb.Pop(); err != nil {
+						assert.ErrorIs(t, err, Closed)
+						return
+					}
+					atomic.AddUint64(&received, 1)
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, uint64(items), atomic.LoadUint64(&received))
+	})
+	t.Run("peek", func(t *testing.T) {
+		rb := NewLockFree[P, *P](4)
+		_, err := rb.Peek()
+		assert.ErrorIs(t, err, EmptyError)
+		packets := make([]*P, 3)
+		for i := range packets {
+			packets[i] = &P{Int: i}
+			require.NoError(t, rb.Push(packets[i]))
+		}
+		actual, err := rb.Peek()
+		require.NoError(t, err)
+		assert.Same(t, packets[0], actual)
+		dst := make([]*P, 4)
+		assert.Equal(t, 3, rb.PeekN(dst))
+		assert.Equal(t, packets, dst[:3])
+		assert.Equal(t, 3, rb.Length())
+
+		actual, err = rb.Pop()
+		require.NoError(t, err)
+		assert.Same(t, packets[0], actual)
+		assert.Equal(t, 2, rb.PeekN(dst[:2]))
+		assert.Equal(t, packets[1:], dst[:2])
+
+		rb.Close()
+		_, err = rb.Peek()
+		assert.ErrorIs(t, err, Closed)
+		assert.Equal(t, 0, rb.PeekN(dst))
+	})
+	t.Run("peek with concurrent consumers", func(t *testing.T) {
+		const items = 4096
+		rb := NewLockFree[P, *P](64, WithDrainOnClose[P, *P]())
+		go func() {
+			for i := 0; i < items; i++ {
+				if err := rb.Push(&P{Int: i}); err != nil {
+					return
+				}
+			}
+			rb.Close()
+		}()
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				if _, err := rb.Pop(); err != nil {
+					return
+				}
+			}
+		}()
+		dst := make([]*P, 8)
+		for !rb.IsClosed() || !rb.IsEmpty() {
+			n := rb.PeekN(dst)
+			for i := 1; i < n; i++ {
+				require.Equal(t, dst[i-1].Int+1, dst[i].Int)
+			}
+			if p, err := rb.Peek(); err == nil {
+				require.NotNil(t, p)
+			}
+		}
+		wg.Wait()
+	})
+	t.Run("notify waiters only", func(t *testing.T) {
+		wait := &countingPark{Park: NewPark(0)}
+		rb := NewLockFree[P, *P](2, WithWaitStrategy[P, *P](wait))
+		for i := 0; i < 2; i++ {
+			require.NoError(t, rb.Push(testPacket()))
+		}
+		_, err := rb.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, 1, len(rb.Drain()))
+		assert.Zero(t, atomic.LoadUint64(&wait.notified))
+
+		doneCh := make(chan *P, 1)
+		go func() {
+			p, err := rb.Pop()
+			assert.NoError(t, err)
+			doneCh <- p
+		}()
+		for atomic.LoadUint64(&rb.poppers) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		p := testPacket2()
+		require.NoError(t, rb.Push(p))
+		select {
+		case actual := <-doneCh:
+			assert.Same(t, p, actual)
+		case <-time.After(time.Second):
+			t.Fatal("LockFree did not unblock on push")
+		}
+		assert.NotZero(t, atomic.LoadUint64(&wait.notified))
+		assert.Zero(t, atomic.LoadUint64(&rb.poppers))
+	})
 }
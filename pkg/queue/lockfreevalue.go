@@ -0,0 +1,361 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"context"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// valueNode is a slot of a LockFreeValue, which keeps track of its own position
+// as well as the element stored in it.
+//
+// Unlike a node, the element is not accessed atomically, it is only ever
+// read or written by the goroutine that owns the slot's current position.
+type valueNode[T any] struct {
+	position uint64
+	data     T
+}
+
+// LockFreeValue is a blocking LockFree queue that stores its elements by value in
+// the ring instead of storing pointers to them, so that small elements like sequence
+// numbers or indices can be queued without allocating them on the heap.
+//
+// The slots are stored inline in a single slice, without the padding that keeps the
+// slots of a LockFree on separate cache lines.
+//
+// It does not share its implementation with LockFree, since a LockFree stores the
+// pointers to its elements atomically so that Peek and the WithOverwrite option can
+// read a slot while another goroutine may be writing to it. An element stored by value
+// cannot be read atomically, so a LockFreeValue only ever accesses a slot from the
+// goroutine that claimed it, and does not support Peek or WithOverwrite.
+type LockFreeValue[T any] struct {
+	_padding0 [8]uint64 //nolint:structcheck,unused
+	head      uint64
+	_padding1 [8]uint64 //nolint:structcheck,unused
+	tail      uint64
+	_padding2 [8]uint64 //nolint:structcheck,unused
+	mask      uint64
+	_padding3 [8]uint64 //nolint:structcheck,unused
+	capacity  uint64
+	_padding4 [8]uint64 //nolint:structcheck,unused
+	closed    uint64
+	_padding5 [8]uint64 //nolint:structcheck,unused
+	nodes     []valueNode[T]
+	_padding6 [8]uint64 //nolint:structcheck,unused
+	wait      WaitStrategy
+	_padding7 [8]uint64 //nolint:structcheck,unused
+	graceful  bool
+	_padding8 [8]uint64 //nolint:structcheck,unused
+	stats     *Stats
+}
+
+// NewLockFreeValue creates a new LockFreeValue that can hold the given number
+// of elements, rounded up to the nearest power of 2.
+//
+// Only the WithWaitStrategy, WithDrainOnClose and WithStats options apply to it.
+func NewLockFreeValue[T any](size uint64, opts ...Option[T, *T]) *LockFreeValue[T] {
+	q := new(LockFreeValue[T])
+	if size < 1 {
+		size = 1
+	}
+	o := newOptions(opts)
+	q.wait = o.wait
+	q.graceful = o.graceful
+	q.stats = o.stats
+
+	// At least two slots are always allocated, for the same reason as in LockFree.init.
+	size = round(size)
+	q.capacity = size
+	if size < 2 {
+		size = 2
+	}
+	q.nodes = make([]valueNode[T], size)
+	for i := uint64(0); i < size; i++ {
+		q.nodes[i].position = i
+	}
+	q.mask = size - 1
+	return q
+}
+
+// Push appends an element to the LockFreeValue, blocking until there is space for it.
+//
+// This method is safe to be used concurrently by multiple producers.
+func (q *LockFreeValue[T]) Push(v T) error {
+	return q.PushContext(context.Background(), v)
+}
+
+// PushContext appends an element to the LockFreeValue the same way Push does, however
+// if the LockFreeValue is full and the context is cancelled while waiting for space,
+// ctx.Err() is returned and the element is not pushed.
+func (q *LockFreeValue[T]) PushContext(ctx context.Context, v T) error {
+	var stop func()
+	var waited time.Time
+	defer func() {
+		q.stats.pushWaited(waited)
+		if stop != nil {
+			stop()
+		}
+	}()
+	var iteration uint64
+	for {
+		epoch := q.wait.Epoch()
+		if atomic.LoadUint64(&q.closed) == 1 {
+			return Closed
+		}
+		if q.tryPush(v) {
+			q.stats.push(1)
+			q.wait.Notify()
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if stop == nil {
+			stop = notifyOnDone(ctx, q.wait)
+		}
+		if waited.IsZero() {
+			waited = q.stats.now()
+		}
+		q.wait.Wait(iteration, epoch)
+		iteration++
+	}
+}
+
+// tryPush claims the next position and stores the element in its slot if the
+// LockFreeValue is not full, without blocking.
+func (q *LockFreeValue[T]) tryPush(v T) bool {
+	head := atomic.LoadUint64(&q.head)
+	for {
+		n := &q.nodes[head&q.mask]
+		switch dif := int64(atomic.LoadUint64(&n.position) - head); {
+		case dif == 0 && head-atomic.LoadUint64(&q.tail) < q.capacity:
+			if atomic.CompareAndSwapUint64(&q.head, head, head+1) {
+				n.data = v
+				atomic.StoreUint64(&n.position, head+1)
+				return true
+			}
+			head = atomic.LoadUint64(&q.head)
+		case dif <= 0:
+			newHead := atomic.LoadUint64(&q.head)
+			if newHead == head {
+				return false
+			}
+			head = newHead
+		default:
+			head = atomic.LoadUint64(&q.head)
+			runtime.Gosched()
+		}
+	}
+}
+
+// PushBatch appends all the given elements to the LockFreeValue in order, returning
+// the number of elements that were pushed.
+//
+// Unlike LockFree, every element claims its own slot, and when the LockFreeValue is full
+// PushBatch blocks until there is space. Elements from a batch that had to wait may be
+// interleaved with elements from other producers.
+func (q *LockFreeValue[T]) PushBatch(vs []T) (n int, err error) {
+	var waited time.Time
+	defer func() {
+		q.stats.pushWaited(waited)
+	}()
+	var iteration uint64
+	for n < len(vs) {
+		epoch := q.wait.Epoch()
+		if atomic.LoadUint64(&q.closed) == 1 {
+			return n, Closed
+		}
+		pushed := n
+		for n < len(vs) && q.tryPush(vs[n]) {
+			n++
+		}
+		if n > pushed {
+			q.stats.push(n - pushed)
+			q.wait.Notify()
+			continue
+		}
+		if waited.IsZero() {
+			waited = q.stats.now()
+		}
+		q.wait.Wait(iteration, epoch)
+		iteration++
+	}
+	return n, nil
+}
+
+// Pop removes an element from the start of the LockFreeValue, blocking until
+// one is available or the LockFreeValue is closed.
+//
+// This method is safe to be used concurrently by multiple consumers.
+func (q *LockFreeValue[T]) Pop() (T, error) {
+	return q.PopContext(context.Background())
+}
+
+// PopContext removes an element from the start of the LockFreeValue the same way Pop does,
+// however if the context is cancelled while waiting for an element, ctx.Err() is returned
+// along with the zero value of T.
+func (q *LockFreeValue[T]) PopContext(ctx context.Context) (v T, err error) {
+	var stop func()
+	var waited time.Time
+	defer func() {
+		q.stats.popWaited(waited)
+		if stop != nil {
+			stop()
+		}
+	}()
+	var iteration uint64
+	var ok bool
+	for {
+		epoch := q.wait.Epoch()
+		if q.isDone() {
+			return v, Closed
+		}
+		if v, ok = q.tryPop(); ok {
+			q.stats.pop(1)
+			q.wait.Notify()
+			return v, nil
+		}
+		if err = ctx.Err(); err != nil {
+			return v, err
+		}
+		if stop == nil {
+			stop = notifyOnDone(ctx, q.wait)
+		}
+		if waited.IsZero() {
+			waited = q.stats.now()
+		}
+		q.wait.Wait(iteration, epoch)
+		iteration++
+	}
+}
+
+// PopBatch removes up to max elements (and at most len(dst)) from the start of the
+// LockFreeValue and stores them in dst, returning the number of elements that were removed.
+//
+// It blocks until at least one element is available, and then removes as many elements
+// as are available, one slot at a time.
+func (q *LockFreeValue[T]) PopBatch(dst []T, max int) (int, error) {
+	if max > len(dst) {
+		max = len(dst)
+	}
+	if max <= 0 {
+		return 0, nil
+	}
+	var waited time.Time
+	defer func() {
+		q.stats.popWaited(waited)
+	}()
+	var iteration uint64
+	for {
+		epoch := q.wait.Epoch()
+		if q.isDone() {
+			return 0, Closed
+		}
+		n := 0
+		for n < max {
+			v, ok := q.tryPop()
+			if !ok {
+				break
+			}
+			dst[n] = v
+			n++
+		}
+		if n > 0 {
+			q.stats.pop(n)
+			q.wait.Notify()
+			return n, nil
+		}
+		if waited.IsZero() {
+			waited = q.stats.now()
+		}
+		q.wait.Wait(iteration, epoch)
+		iteration++
+	}
+}
+
+// tryPop removes an element from the start of the LockFreeValue if one is available,
+// without blocking, clearing its slot so that the LockFreeValue does not keep anything
+// it refers to alive.
+func (q *LockFreeValue[T]) tryPop() (v T, ok bool) {
+	var zero T
+	tail := atomic.LoadUint64(&q.tail)
+	for {
+		n := &q.nodes[tail&q.mask]
+		switch dif := int64(atomic.LoadUint64(&n.position) - (tail + 1)); {
+		case dif == 0:
+			if atomic.CompareAndSwapUint64(&q.tail, tail, tail+1) {
+				v = n.data
+				n.data = zero
+				atomic.StoreUint64(&n.position, tail+q.mask+1)
+				return v, true
+			}
+			tail = atomic.LoadUint64(&q.tail)
+		case dif < 0:
+			return zero, false
+		default:
+			tail = atomic.LoadUint64(&q.tail)
+		}
+	}
+}
+
+// Close marks the LockFreeValue as closed, returns any waiting Push or Pop
+// calls, and blocks all future Push calls from occurring.
+func (q *LockFreeValue[T]) Close() {
+	if atomic.CompareAndSwapUint64(&q.closed, 0, 1) {
+		q.wait.Notify()
+	}
+}
+
+// isDone returns whether Pop operations should return Closed, which is as soon as the
+// LockFreeValue is closed, or once it is also empty if it was created with WithDrainOnClose.
+func (q *LockFreeValue[T]) isDone() bool {
+	return atomic.LoadUint64(&q.closed) == 1 && (!q.graceful || q.Length() == 0)
+}
+
+// IsEmpty returns whether the LockFreeValue is currently empty
+func (q *LockFreeValue[T]) IsEmpty() bool {
+	return q.Length() == 0
+}
+
+// IsFull returns whether the LockFreeValue is currently full
+func (q *LockFreeValue[T]) IsFull() bool {
+	return uint64(q.Length()) >= q.capacity
+}
+
+// IsClosed returns whether the LockFreeValue has been closed
+func (q *LockFreeValue[T]) IsClosed() bool {
+	return atomic.LoadUint64(&q.closed) == 1
+}
+
+// Length is the current number of elements in the LockFreeValue
+//
+// The tail is loaded before the head, so that a concurrent Pop can never make the
+// length appear negative.
+func (q *LockFreeValue[T]) Length() int {
+	tail := atomic.LoadUint64(&q.tail)
+	return int(atomic.LoadUint64(&q.head) - tail)
+}
+
+// Drain removes all the elements that are currently in the LockFreeValue and returns them.
+//
+// It never blocks, and is safe to call at any time, however it is meant to be used after
+// the LockFreeValue has been closed. Elements that are still being pushed by a concurrent
+// producer when Drain reaches them are not returned.
+func (q *LockFreeValue[T]) Drain() []T {
+	values := make([]T, 0, q.Length())
+	for {
+		v, ok := q.tryPop()
+		if !ok {
+			break
+		}
+		values = append(values, v)
+	}
+	if len(values) > 0 {
+		q.stats.pop(len(values))
+		q.wait.Notify()
+	}
+	return values
+}
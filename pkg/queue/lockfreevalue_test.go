@@ -0,0 +1,130 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockFreeValue(t *testing.T) {
+	t.Parallel()
+
+	t.Run("success", func(t *testing.T) {
+		rb := NewLockFreeValue[P](1)
+		require.NoError(t, rb.Push(P{Int: 1, String: "1"}))
+		actual, err := rb.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, P{Int: 1, String: "1"}, actual)
+		assert.True(t, rb.IsEmpty())
+	})
+	t.Run("blocking", func(t *testing.T) {
+		rb := NewLockFreeValue[int](1)
+		require.NoError(t, rb.Push(1))
+		assert.True(t, rb.IsFull())
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+		defer cancel()
+		assert.ErrorIs(t, rb.PushContext(ctx, 2), context.DeadlineExceeded)
+
+		doneCh := make(chan error, 1)
+		go func() {
+			doneCh <- rb.Push(2)
+		}()
+		select {
+		case <-doneCh:
+			t.Fatal("LockFreeValue did not block on full write")
+		case <-time.After(time.Millisecond * 10):
+		}
+		v, err := rb.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, 1, v)
+		select {
+		case err = <-doneCh:
+			require.NoError(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("LockFreeValue did not unblock on pop")
+		}
+		v, err = rb.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, 2, v)
+
+		ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*10)
+		defer cancel()
+		v, err = rb.PopContext(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Zero(t, v)
+	})
+	t.Run("multiple producers, multiple consumers", func(t *testing.T) {
+		const producers = 4
+		const count = 1000
+		rb := NewLockFreeValue[int](8, WithDrainOnClose[int, *int]())
+
+		var producerWg sync.WaitGroup
+		producerWg.Add(producers)
+		for i := 0; i < producers; i++ {
+			go func(offset int) {
+				defer producerWg.Done()
+				for j := 0; j < count; j++ {
+					assert.NoError(t, rb.Push(offset*count+j))
+				}
+			}(i)
+		}
+
+		var consumerWg sync.WaitGroup
+		var lock sync.Mutex
+		seen := make(map[int]struct{}, producers*count)
+		consumerWg.Add(producers)
+		for i := 0; i < producers; i++ {
+			go func() {
+				defer consumerWg.Done()
+				for {
+					v, err := rb.Pop()
+					if err != nil {
+						assert.ErrorIs(t, err, Closed)
+						return
+					}
+					lock.Lock()
+					seen[v] = struct{}{}
+					lock.Unlock()
+				}
+			}()
+		}
+
+		producerWg.Wait()
+		rb.Close()
+		consumerWg.Wait()
+		assert.Len(t, seen, producers*count)
+	})
+	t.Run("close", func(t *testing.T) {
+		rb := NewLockFreeValue[int](4)
+		for i := 0; i < 3; i++ {
+			require.NoError(t, rb.Push(i))
+		}
+		rb.Close()
+		assert.True(t, rb.IsClosed())
+		assert.ErrorIs(t, rb.Push(3), Closed)
+		_, err := rb.Pop()
+		assert.ErrorIs(t, err, Closed)
+		assert.Equal(t, []int{0, 1, 2}, rb.Drain())
+		assert.Empty(t, rb.Drain())
+	})
+	t.Run("batch", func(t *testing.T) {
+		rb := NewLockFreeValue[int](4)
+		n, err := rb.PushBatch([]int{0, 1, 2})
+		require.NoError(t, err)
+		assert.Equal(t, 3, n)
+		dst := make([]int, 4)
+		n, err = rb.PopBatch(dst, 2)
+		require.NoError(t, err)
+		assert.Equal(t, []int{0, 1}, dst[:n])
+		n, err = rb.PopBatch(dst, 4)
+		require.NoError(t, err)
+		assert.Equal(t, []int{2}, dst[:n])
+	})
+}
@@ -6,13 +6,37 @@ import (
 	"sync"
 )
 
+// OverflowPolicy determines what a NonBlocking queue does when
+// an element is pushed while it is full.
+type OverflowPolicy int
+
+const (
+	// OverflowReject rejects the new element and returns FullError.
+	OverflowReject OverflowPolicy = iota
+
+	// OverflowDropNewest silently drops the new element.
+	OverflowDropNewest
+
+	// OverflowDropOldest drops the oldest element in the queue
+	// to make space for the new element.
+	OverflowDropOldest
+)
+
 // NonBlocking is a circular sized FIFO queue that uses
-// an array of fixed size to store the elements.
+// an array of fixed size to store the elements, which
+// can be changed with the Resize method.
 //
 // It is thread safe and extremely performant, however
 // it is a blocking queue and will block the caller
 // if the queue is full or if it is empty.
 type NonBlocking[T any, P Pointer[T]] struct {
+	nonBlocking[P]
+}
+
+// nonBlocking implements NonBlocking and NonBlockingValue for any type of element
+// E, which is the pointer to the element for a NonBlocking and the element itself
+// for a NonBlockingValue.
+type nonBlocking[E any] struct {
 	_padding0 [8]uint64 //nolint:structcheck,unused
 	head      uint64
 	_padding1 [8]uint64 //nolint:structcheck,unused
@@ -24,12 +48,37 @@ type NonBlocking[T any, P Pointer[T]] struct {
 	_padding4 [8]uint64 //nolint:structcheck,unused
 	lock      *sync.Mutex
 	_padding5 [8]uint64 //nolint:structcheck,unused
-	nodes     []P
+	nodes     []E
+	_padding6 [8]uint64 //nolint:structcheck,unused
+	graceful  bool
+	_padding7 [8]uint64 //nolint:structcheck,unused
+	overflow  OverflowPolicy
+	_padding8 [8]uint64 //nolint:structcheck,unused
+	dropped   func(E)
+	_padding9 [8]uint64 //nolint:structcheck,unused
+	stats     *Stats
 }
 
 // NewNonBlocking creates a new circular queue with the given size.
-func NewNonBlocking[T any, P Pointer[T]](maxSize uint64) *NonBlocking[T, P] {
+//
+// By default Pop returns Closed as soon as the queue is closed, the WithDrainOnClose
+// option can be used to keep returning the remaining elements first.
+//
+// By default Push returns FullError when the queue is full, the WithOverflowPolicy
+// option can be used to drop elements instead.
+func NewNonBlocking[T any, P Pointer[T]](maxSize uint64, opts ...Option[T, P]) *NonBlocking[T, P] {
 	q := new(NonBlocking[T, P])
+	o := newOptions(opts)
+	q.init(maxSize, o.graceful, o.overflow, o.dropped, o.stats)
+	return q
+}
+
+// init is an internal function used to initialize a queue with the given size.
+func (q *nonBlocking[E]) init(maxSize uint64, graceful bool, overflow OverflowPolicy, dropped func(E), stats *Stats) {
+	q.graceful = graceful
+	q.overflow = overflow
+	q.dropped = dropped
+	q.stats = stats
 	q.lock = new(sync.Mutex)
 	q.head = 0
 	q.tail = 0
@@ -40,12 +89,11 @@ func NewNonBlocking[T any, P Pointer[T]](maxSize uint64) *NonBlocking[T, P] {
 		q.maxSize = round(maxSize)
 	}
 
-	q.nodes = make([]P, q.maxSize)
-	return q
+	q.nodes = make([]E, q.maxSize)
 }
 
 // IsEmpty returns true if the queue is empty.
-func (q *NonBlocking[T, P]) IsEmpty() (empty bool) {
+func (q *nonBlocking[E]) IsEmpty() (empty bool) {
 	q.lock.Lock()
 	empty = q.isEmpty()
 	q.lock.Unlock()
@@ -54,12 +102,12 @@ func (q *NonBlocking[T, P]) IsEmpty() (empty bool) {
 
 // isEmpty is an internal function used to check if the
 // queue is empty.
-func (q *NonBlocking[T, P]) isEmpty() bool {
+func (q *nonBlocking[E]) isEmpty() bool {
 	return q.head == q.tail
 }
 
 // IsFull returns true if the queue is full.
-func (q *NonBlocking[T, P]) IsFull() (full bool) {
+func (q *nonBlocking[E]) IsFull() (full bool) {
 	q.lock.Lock()
 	full = q.isFull()
 	q.lock.Unlock()
@@ -68,14 +116,14 @@ func (q *NonBlocking[T, P]) IsFull() (full bool) {
 
 // isFull is an internal function used to check if the
 // queue is full.
-func (q *NonBlocking[T, P]) isFull() bool {
+func (q *nonBlocking[E]) isFull() bool {
 	return q.head == (q.tail+1)%q.maxSize
 }
 
 // IsClosed returns true if the queue is Closed
 //
 // The Drain method can be used to drain the queue after it is closed.
-func (q *NonBlocking[T, P]) IsClosed() (closed bool) {
+func (q *nonBlocking[E]) IsClosed() (closed bool) {
 	q.lock.Lock()
 	closed = q.isClosed()
 	q.lock.Unlock()
@@ -84,12 +132,19 @@ func (q *NonBlocking[T, P]) IsClosed() (closed bool) {
 
 // isClosed is an internal function used to check if the
 // queue is closed.
-func (q *NonBlocking[T, P]) isClosed() bool {
+func (q *nonBlocking[E]) isClosed() bool {
 	return q.closed
 }
 
+// isDone is an internal function used to check if Pop operations
+// should return Closed, which is as soon as the queue is closed, or once
+// it is also empty if it was created with WithDrainOnClose.
+func (q *nonBlocking[E]) isDone() bool {
+	return q.closed && (!q.graceful || q.isEmpty())
+}
+
 // Length returns the number of elements in the queue.
-func (q *NonBlocking[T, P]) Length() (size int) {
+func (q *nonBlocking[E]) Length() (size int) {
 	q.lock.Lock()
 	size = q.length()
 	q.lock.Unlock()
@@ -97,76 +152,275 @@ func (q *NonBlocking[T, P]) Length() (size int) {
 }
 
 // length is an internal function used to get the number of elements in the queue.
-func (q *NonBlocking[T, P]) length() int {
+func (q *nonBlocking[E]) length() int {
 	if q.tail < q.head {
 		return int(q.maxSize - q.head + q.tail)
 	}
 	return int(q.tail - q.head)
 }
 
+// Resize changes the number of elements the queue can hold, rounding it the
+// same way the constructor does, while preserving the order of the elements.
+//
+// If the new size is smaller than the current length of the queue, SizeError
+// is returned and the queue is left unchanged.
+func (q *nonBlocking[E]) Resize(maxSize uint64) error {
+	maxSize++
+	if maxSize < 2 {
+		maxSize = 2
+	} else {
+		maxSize = round(maxSize)
+	}
+	q.lock.Lock()
+	length := q.length()
+	if uint64(length) > maxSize-1 {
+		q.lock.Unlock()
+		return SizeError
+	}
+	nodes := make([]E, maxSize)
+	copyRing(nodes, q.nodes, q.head, q.tail)
+	q.nodes = nodes
+	q.maxSize = maxSize
+	q.head = 0
+	q.tail = uint64(length)
+	q.lock.Unlock()
+	return nil
+}
+
 // Close closes the queue permanently.
 //
 // The Drain method can be used to drain the queue after it is closed.
-func (q *NonBlocking[T, P]) Close() {
+func (q *nonBlocking[E]) Close() {
 	q.lock.Lock()
 	q.closed = true
 	q.lock.Unlock()
 }
 
 // Push adds an element to the queue.
-func (q *NonBlocking[T, P]) Push(p P) error {
+//
+// If the queue is full, the queue's OverflowPolicy determines whether the element
+// is rejected with FullError, or whether the new or the oldest element is dropped.
+func (q *nonBlocking[E]) Push(p E) error {
 	q.lock.Lock()
 	if q.isClosed() {
 		q.lock.Unlock()
 		return Closed
 	}
 	if q.isFull() {
-		q.lock.Unlock()
-		return FullError
+		switch q.overflow {
+		case OverflowDropNewest:
+			q.stats.drop(1)
+			q.lock.Unlock()
+			q.drop(p)
+			return nil
+		case OverflowDropOldest:
+			oldest := q.pop()
+			q.nodes[q.tail] = p
+			q.tail = (q.tail + 1) % q.maxSize
+			q.stats.evict(1)
+			q.stats.push(1)
+			q.lock.Unlock()
+			q.drop(oldest)
+			return nil
+		default:
+			q.lock.Unlock()
+			return FullError
+		}
 	}
 	q.nodes[q.tail] = p
 	q.tail = (q.tail + 1) % q.maxSize
+	q.stats.push(1)
 	q.lock.Unlock()
 	return nil
 }
 
+// drop is an internal function used to pass an element that was dropped
+// by the OverflowPolicy to the dropped callback, if there is one.
+func (q *nonBlocking[E]) drop(p E) {
+	if q.dropped != nil {
+		q.dropped(p)
+	}
+}
+
 // Pop removes an element from the queue.
-func (q *NonBlocking[T, P]) Pop() (p P, err error) {
+func (q *nonBlocking[E]) Pop() (p E, err error) {
+	q.lock.Lock()
+	if q.isDone() {
+		q.lock.Unlock()
+		return p, Closed
+	}
+	if q.isEmpty() {
+		q.lock.Unlock()
+		return p, EmptyError
+	}
+
+	p = q.pop()
+	q.stats.pop(1)
+	q.lock.Unlock()
+	return
+}
+
+// PushBatch adds as many of the given elements to the queue as there is space for
+// in a single critical section, returning the number of elements that were added.
+//
+// If not all the elements could be added, FullError is returned, unless the queue's
+// OverflowPolicy drops elements, in which case the elements that did not fit (or the
+// oldest elements in the queue, for OverflowDropOldest) are dropped and all the
+// given elements are counted as added.
+func (q *nonBlocking[E]) PushBatch(ps []E) (n int, err error) {
+	if len(ps) == 0 {
+		return 0, nil
+	}
 	q.lock.Lock()
 	if q.isClosed() {
 		q.lock.Unlock()
-		return nil, Closed
+		return 0, Closed
+	}
+	var dropped []E
+	evicted := 0
+	for ; n < len(ps); n++ {
+		if q.isFull() {
+			if q.overflow != OverflowDropOldest {
+				break
+			}
+			oldest := q.pop()
+			if q.dropped != nil {
+				dropped = append(dropped, oldest)
+			}
+			evicted++
+		}
+		q.nodes[q.tail] = ps[n]
+		q.tail = (q.tail + 1) % q.maxSize
+	}
+	q.stats.evict(evicted)
+	q.stats.push(n)
+	if n < len(ps) && q.overflow == OverflowDropNewest {
+		q.stats.drop(len(ps) - n)
+	}
+	q.lock.Unlock()
+	for _, p := range dropped {
+		q.drop(p)
+	}
+	if n < len(ps) {
+		if q.overflow == OverflowDropNewest {
+			for _, p := range ps[n:] {
+				q.drop(p)
+			}
+			return len(ps), nil
+		}
+		return n, FullError
+	}
+	return n, nil
+}
+
+// PopBatch removes up to max elements (and at most len(dst)) from the queue
+// in a single critical section and stores them in dst, returning the
+// number of elements that were removed.
+//
+// If the queue is empty, EmptyError is returned.
+func (q *nonBlocking[E]) PopBatch(dst []E, max int) (n int, err error) {
+	if max > len(dst) {
+		max = len(dst)
+	}
+	if max <= 0 {
+		return 0, nil
+	}
+	q.lock.Lock()
+	if q.isDone() {
+		q.lock.Unlock()
+		return 0, Closed
 	}
 	if q.isEmpty() {
 		q.lock.Unlock()
-		return nil, EmptyError
+		return 0, EmptyError
+	}
+	for ; n < max && !q.isEmpty(); n++ {
+		dst[n] = q.pop()
 	}
+	q.stats.pop(n)
+	q.lock.Unlock()
+	return
+}
 
+// Peek returns the element at the start of the queue without removing it.
+//
+// If the queue is empty, EmptyError is returned.
+func (q *nonBlocking[E]) Peek() (p E, err error) {
+	q.lock.Lock()
+	if q.isDone() {
+		q.lock.Unlock()
+		return p, Closed
+	}
+	if q.isEmpty() {
+		q.lock.Unlock()
+		return p, EmptyError
+	}
 	p = q.nodes[q.head]
-	q.head = (q.head + 1) % q.maxSize
 	q.lock.Unlock()
 	return
 }
 
-// Drain removes all elements from the queue.
-// and returns them in a slice.
+// PeekN stores up to len(dst) elements from the start of the queue in dst, in order,
+// without removing them, and returns the number of elements that were stored.
+//
+// Like Peek, it stops returning elements as soon as Pop would return Closed, so it
+// returns 0 once the queue is closed, or once it is also empty if it was created with
+// WithDrainOnClose.
+func (q *nonBlocking[E]) PeekN(dst []E) (n int) {
+	q.lock.Lock()
+	if !q.isDone() {
+		n = copyRing(dst, q.nodes, q.head, q.tail)
+	}
+	q.lock.Unlock()
+	return
+}
+
+// Drain removes all elements from the queue and returns them in a slice.
 //
 // This function should only be called after the queue is closed.
-func (q *NonBlocking[T, P]) Drain() (values []P) {
+func (q *nonBlocking[E]) Drain() (values []E) {
 	q.lock.Lock()
 	if q.isEmpty() {
 		q.lock.Unlock()
 		return nil
 	}
-	if size := int(q.head) - int(q.tail); size > 0 {
-		values = make([]P, 0, size)
-	} else {
-		values = make([]P, 0, -1*size)
-	}
-	for i := 0; i < cap(values); i++ {
-		values = append(values, q.nodes[q.head])
-		q.head = (q.head + 1) % q.maxSize
-	}
+	values = make([]E, q.length())
+	q.drainTo(values)
 	q.lock.Unlock()
 	return values
 }
+
+// DrainTo removes up to len(dst) elements from the queue and stores them in dst,
+// in order, returning the number of elements that were removed.
+//
+// It never blocks and does not allocate, so the same dst can be reused
+// to drain the queue in several steps.
+func (q *nonBlocking[E]) DrainTo(dst []E) (n int) {
+	q.lock.Lock()
+	n = q.drainTo(dst)
+	q.lock.Unlock()
+	return
+}
+
+// pop is an internal function used to remove the element at the head of the
+// queue, clearing its slot so that the queue does not keep anything it refers to alive.
+func (q *nonBlocking[E]) pop() (e E) {
+	var zero E
+	e = q.nodes[q.head]
+	q.nodes[q.head] = zero
+	q.head = (q.head + 1) % q.maxSize
+	return
+}
+
+// drainTo is an internal function used to remove up to len(dst) elements
+// from the queue and store them in dst, clearing the slots they were stored in.
+func (q *nonBlocking[E]) drainTo(dst []E) int {
+	n := copyRing(dst, q.nodes, q.head, q.tail)
+	for i := 0; i < n; i++ {
+		q.pop()
+	}
+	if n > 0 {
+		q.stats.pop(n)
+	}
+	return n
+}
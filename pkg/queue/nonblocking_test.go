@@ -0,0 +1,276 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"testing"
+	"testing/quick"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNonBlocking(t *testing.T) {
+	t.Parallel()
+
+	testPacket := func() *P {
+		return new(P)
+	}
+
+	t.Run("success", func(t *testing.T) {
+		rb := NewNonBlocking[P, *P](1)
+		p := testPacket()
+		err := rb.Push(p)
+		assert.NoError(t, err)
+		actual, err := rb.Pop()
+		assert.NoError(t, err)
+		assert.Equal(t, p, actual)
+	})
+	t.Run("out of capacity", func(t *testing.T) {
+		rb := NewNonBlocking[P, *P](1)
+		err := rb.Push(testPacket())
+		assert.NoError(t, err)
+		err = rb.Push(testPacket())
+		assert.ErrorIs(t, err, FullError)
+	})
+	t.Run("pop empty", func(t *testing.T) {
+		rb := NewNonBlocking[P, *P](1)
+		_, err := rb.Pop()
+		assert.ErrorIs(t, err, EmptyError)
+	})
+	t.Run("buffer closed", func(t *testing.T) {
+		rb := NewNonBlocking[P, *P](1)
+		assert.False(t, rb.IsClosed())
+		rb.Close()
+		assert.True(t, rb.IsClosed())
+		err := rb.Push(testPacket())
+		assert.ErrorIs(t, err, Closed)
+		_, err = rb.Pop()
+		assert.ErrorIs(t, err, Closed)
+	})
+	t.Run("batch", func(t *testing.T) {
+		rb := NewNonBlocking[P, *P](4)
+		packets := make([]*P, 10)
+		for i := range packets {
+			packets[i] = testPacket()
+			packets[i].Int = i
+		}
+		n, err := rb.PushBatch(packets)
+		assert.ErrorIs(t, err, FullError)
+		assert.Equal(t, 7, n)
+		assert.Equal(t, 7, rb.Length())
+
+		dst := make([]*P, 4)
+		n, err = rb.PopBatch(dst, 10)
+		require.NoError(t, err)
+		assert.Equal(t, 4, n)
+		assert.Equal(t, packets[:4], dst)
+
+		n, err = rb.PopBatch(dst, 10)
+		require.NoError(t, err)
+		assert.Equal(t, 3, n)
+		assert.Equal(t, packets[4:7], dst[:n])
+
+		_, err = rb.PopBatch(dst, 10)
+		assert.ErrorIs(t, err, EmptyError)
+	})
+	t.Run("drain on close", func(t *testing.T) {
+		rb := NewNonBlocking[P, *P](4, WithDrainOnClose[P, *P]())
+		p := testPacket()
+		require.NoError(t, rb.Push(p))
+		rb.Close()
+		assert.ErrorIs(t, rb.Push(testPacket()), Closed)
+
+		actual, err := rb.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, p, actual)
+		_, err = rb.Pop()
+		assert.ErrorIs(t, err, Closed)
+	})
+	t.Run("resize", func(t *testing.T) {
+		rb := NewNonBlocking[P, *P](1)
+		p1 := testPacket()
+		require.NoError(t, rb.Push(p1))
+		assert.ErrorIs(t, rb.Push(testPacket()), FullError)
+
+		require.NoError(t, rb.Resize(3))
+		p2 := testPacket()
+		p3 := testPacket()
+		require.NoError(t, rb.Push(p2))
+		require.NoError(t, rb.Push(p3))
+		assert.True(t, rb.IsFull())
+		assert.ErrorIs(t, rb.Resize(1), SizeError)
+
+		drained := rb.Drain()
+		require.Len(t, drained, 3)
+		assert.Same(t, p1, drained[0])
+		assert.Same(t, p2, drained[1])
+		assert.Same(t, p3, drained[2])
+	})
+	t.Run("overflow drop newest", func(t *testing.T) {
+		var dropped []*P
+		rb := NewNonBlocking[P, *P](1, WithOverflowPolicy[P, *P](OverflowDropNewest, func(p *P) {
+			dropped = append(dropped, p)
+		}))
+		p1 := testPacket()
+		p2 := testPacket()
+		require.NoError(t, rb.Push(p1))
+		require.NoError(t, rb.Push(p2))
+		assert.Equal(t, []*P{p2}, dropped)
+
+		p3 := testPacket()
+		n, err := rb.PushBatch([]*P{p3})
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+		assert.Equal(t, []*P{p2, p3}, dropped)
+
+		actual, err := rb.Pop()
+		require.NoError(t, err)
+		assert.Same(t, p1, actual)
+	})
+	t.Run("overflow drop oldest", func(t *testing.T) {
+		var dropped []*P
+		rb := NewNonBlocking[P, *P](3, WithOverflowPolicy[P, *P](OverflowDropOldest, func(p *P) {
+			dropped = append(dropped, p)
+		}))
+		packets := make([]*P, 6)
+		for i := range packets {
+			packets[i] = testPacket()
+			packets[i].Int = i
+		}
+		for _, p := range packets[:4] {
+			require.NoError(t, rb.Push(p))
+		}
+		assert.Equal(t, []*P{packets[0]}, dropped)
+		assert.True(t, rb.IsFull())
+
+		n, err := rb.PushBatch(packets[4:])
+		require.NoError(t, err)
+		assert.Equal(t, 2, n)
+		assert.Equal(t, packets[:3], dropped)
+		for _, p := range packets[3:] {
+			actual, err := rb.Pop()
+			require.NoError(t, err)
+			assert.Same(t, p, actual)
+		}
+	})
+	t.Run("overflow without callback", func(t *testing.T) {
+		rb := NewNonBlocking[P, *P](1, WithOverflowPolicy[P, *P](OverflowDropOldest, nil))
+		p1 := testPacket()
+		p2 := testPacket()
+		require.NoError(t, rb.Push(p1))
+		require.NoError(t, rb.Push(p2))
+		n, err := rb.PushBatch([]*P{p1, p2})
+		require.NoError(t, err)
+		assert.Equal(t, 2, n)
+		actual, err := rb.Pop()
+		require.NoError(t, err)
+		assert.Same(t, p2, actual)
+	})
+	t.Run("overflow with overwrite option", func(t *testing.T) {
+		var dropped, evicted []*P
+		rb := NewNonBlocking[P, *P](1,
+			WithOverflowPolicy[P, *P](OverflowDropNewest, func(p *P) {
+				dropped = append(dropped, p)
+			}),
+			WithOverwrite[P, *P](func(p *P) {
+				evicted = append(evicted, p)
+			}))
+		p1 := testPacket()
+		p2 := testPacket()
+		require.NoError(t, rb.Push(p1))
+		require.NoError(t, rb.Push(p2))
+		assert.Equal(t, []*P{p2}, dropped)
+		assert.Empty(t, evicted)
+	})
+	t.Run("peek", func(t *testing.T) {
+		rb := NewNonBlocking[P, *P](3)
+		_, err := rb.Peek()
+		assert.ErrorIs(t, err, EmptyError)
+		assert.Equal(t, 0, rb.PeekN(make([]*P, 2)))
+		p1 := testPacket()
+		p2 := testPacket()
+		require.NoError(t, rb.Push(p1))
+		require.NoError(t, rb.Push(p2))
+
+		actual, err := rb.Peek()
+		require.NoError(t, err)
+		assert.Same(t, p1, actual)
+		dst := make([]*P, 3)
+		assert.Equal(t, 2, rb.PeekN(dst))
+		assert.Equal(t, []*P{p1, p2, nil}, dst)
+
+		actual, err = rb.Pop()
+		require.NoError(t, err)
+		assert.Same(t, p1, actual)
+		actual, err = rb.Peek()
+		require.NoError(t, err)
+		assert.Same(t, p2, actual)
+
+		rb.Close()
+		_, err = rb.Peek()
+		assert.ErrorIs(t, err, Closed)
+		assert.Equal(t, 0, rb.PeekN(dst))
+	})
+	t.Run("drain wrapped", func(t *testing.T) {
+		// Random sequences of pushes and pops move the head and tail around the
+		// ring, and whatever is left is drained either at once or in chunks.
+		property := func(size uint8, ops []bool, chunk uint8) bool {
+			rb := NewNonBlocking[P, *P](uint64(size%16) + 1)
+			var model []*P
+			for _, op := range ops {
+				if op {
+					p := &P{Int: len(model)}
+					switch err := rb.Push(p); err {
+					case nil:
+						model = append(model, p)
+					case FullError:
+						if !rb.IsFull() {
+							return false
+						}
+					default:
+						return false
+					}
+					continue
+				}
+				p, err := rb.Pop()
+				if len(model) == 0 {
+					if err != EmptyError {
+						return false
+					}
+					continue
+				}
+				if err != nil || p != model[0] {
+					return false
+				}
+				model = model[1:]
+			}
+			if rb.Length() != len(model) {
+				return false
+			}
+			var values []*P
+			if chunk%4 == 0 {
+				values = rb.Drain()
+			} else {
+				dst := make([]*P, chunk%4)
+				for {
+					n := rb.DrainTo(dst)
+					if n == 0 {
+						break
+					}
+					values = append(values, dst[:n]...)
+				}
+			}
+			if len(values) != len(model) || !rb.IsEmpty() {
+				return false
+			}
+			for i := range model {
+				if values[i] != model[i] {
+					return false
+				}
+			}
+			return rb.Drain() == nil
+		}
+		require.NoError(t, quick.Check(property, &quick.Config{MaxCount: 1000}))
+	})
+}
@@ -0,0 +1,29 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+// NonBlockingValue is a NonBlocking queue that stores its elements by value in
+// the ring instead of storing pointers to them, so that small elements like
+// sequence numbers or indices can be queued without allocating them on the heap.
+//
+// It shares its implementation with NonBlocking, and supports the same operations.
+type NonBlockingValue[T any] struct {
+	nonBlocking[T]
+}
+
+// NewNonBlockingValue creates a new NonBlockingValue queue with the given size.
+//
+// It accepts the same options as NewNonBlocking. The callback given to
+// WithOverflowPolicy is passed a pointer to a copy of every dropped element.
+func NewNonBlockingValue[T any](maxSize uint64, opts ...Option[T, *T]) *NonBlockingValue[T] {
+	q := new(NonBlockingValue[T])
+	o := newOptions(opts)
+	var dropped func(T)
+	if o.dropped != nil {
+		dropped = func(v T) {
+			o.dropped(&v)
+		}
+	}
+	q.init(maxSize, o.graceful, o.overflow, dropped, o.stats)
+	return q
+}
@@ -0,0 +1,73 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNonBlockingValue(t *testing.T) {
+	t.Parallel()
+
+	t.Run("success", func(t *testing.T) {
+		rb := NewNonBlockingValue[P](1)
+		require.NoError(t, rb.Push(P{Int: 1, String: "1"}))
+		actual, err := rb.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, P{Int: 1, String: "1"}, actual)
+	})
+	t.Run("out of capacity", func(t *testing.T) {
+		rb := NewNonBlockingValue[int](1)
+		require.NoError(t, rb.Push(1))
+		assert.True(t, rb.IsFull())
+		assert.ErrorIs(t, rb.Push(2), FullError)
+	})
+	t.Run("pop empty", func(t *testing.T) {
+		rb := NewNonBlockingValue[int](1)
+		v, err := rb.Pop()
+		assert.ErrorIs(t, err, EmptyError)
+		assert.Zero(t, v)
+	})
+	t.Run("wrap around", func(t *testing.T) {
+		rb := NewNonBlockingValue[int](3)
+		for i := 0; i < 2; i++ {
+			require.NoError(t, rb.Push(i))
+		}
+		_, err := rb.Pop()
+		require.NoError(t, err)
+		for i := 2; i < 4; i++ {
+			require.NoError(t, rb.Push(i))
+		}
+		assert.Equal(t, 3, rb.Length())
+		rb.Close()
+		assert.ErrorIs(t, rb.Push(4), Closed)
+		assert.Equal(t, []int{1, 2, 3}, rb.Drain())
+		assert.True(t, rb.IsEmpty())
+	})
+	t.Run("drain on close", func(t *testing.T) {
+		rb := NewNonBlockingValue[int](2, WithDrainOnClose[int, *int]())
+		require.NoError(t, rb.Push(1))
+		rb.Close()
+		assert.True(t, rb.IsClosed())
+		v, err := rb.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, 1, v)
+		_, err = rb.Pop()
+		assert.ErrorIs(t, err, Closed)
+	})
+	t.Run("overflow drop oldest", func(t *testing.T) {
+		var dropped []int
+		rb := NewNonBlockingValue[int](3, WithOverflowPolicy[int, *int](OverflowDropOldest, func(v *int) {
+			dropped = append(dropped, *v)
+		}))
+		n, err := rb.PushBatch([]int{1, 2, 3, 4})
+		require.NoError(t, err)
+		assert.Equal(t, 4, n)
+		require.NoError(t, rb.Push(5))
+		assert.Equal(t, []int{1, 2}, dropped)
+		assert.Equal(t, []int{3, 4, 5}, rb.Drain())
+	})
+}
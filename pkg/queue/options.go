@@ -0,0 +1,148 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+// Option is used to configure the behavior of a queue when it is created.
+type Option[T any, P Pointer[T]] func(*options[T, P])
+
+// options is the set of configurable behaviors shared between the queues,
+// each queue only uses the options that apply to it.
+type options[T any, P Pointer[T]] struct {
+	overwrite   bool
+	evicted     func(P)
+	wait        WaitStrategy
+	graceful    bool
+	shrinkAfter uint64
+	overflow    OverflowPolicy
+	dropped     func(P)
+	stats       *Stats
+	segmentSize int64
+	sizer       func(P) uint64
+	oversize    OversizePolicy
+	maxBytes    uint64
+}
+
+// newOptions applies the given Options on top of the defaults.
+func newOptions[T any, P Pointer[T]](opts []Option[T, P]) *options[T, P] {
+	o := new(options[T, P])
+	o.wait = NewYield()
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// sizeOf returns the function used to get the cost of an element, which is the one
+// set with WithSizer, or the default described there if none was set.
+func (o *options[T, P]) sizeOf() func(P) uint64 {
+	if o.sizer != nil {
+		return o.sizer
+	}
+	if _, ok := any(P(nil)).(Sizer); ok {
+		return func(p P) uint64 {
+			return any(p).(Sizer).Size()
+		}
+	}
+	return func(P) uint64 {
+		return 1
+	}
+}
+
+// WithOverwrite makes a LockFree queue act as a ringbuffer, evicting the oldest
+// element when a new one is pushed while the queue is full instead of blocking.
+//
+// If evicted is not nil it is called with every element that is evicted, which
+// allows the caller to release it (for example back to a pool).
+func WithOverwrite[T any, P Pointer[T]](evicted func(P)) Option[T, P] {
+	return func(o *options[T, P]) {
+		o.overwrite = true
+		o.evicted = evicted
+	}
+}
+
+// WithWaitStrategy sets the WaitStrategy a LockFree, SPSC, Broadcast or Sharded queue uses when an operation
+// cannot make progress. By default, the Yield WaitStrategy is used.
+func WithWaitStrategy[T any, P Pointer[T]](wait WaitStrategy) Option[T, P] {
+	return func(o *options[T, P]) {
+		o.wait = wait
+	}
+}
+
+// WithOverflowPolicy sets the OverflowPolicy a NonBlocking queue applies when an element
+// is pushed while the queue is full. By default, the OverflowReject policy is used.
+//
+// If dropped is not nil it is called with every element that is dropped by the policy,
+// outside the queue's critical section, which allows the caller to release it (for
+// example back to a pool).
+func WithOverflowPolicy[T any, P Pointer[T]](policy OverflowPolicy, dropped func(P)) Option[T, P] {
+	return func(o *options[T, P]) {
+		o.overflow = policy
+		o.dropped = dropped
+	}
+}
+
+// WithDrainOnClose makes Pop operations keep returning the elements that are still
+// in the queue after it is closed, and only return Closed once the queue is both
+// closed and empty. Push operations still return Closed as soon as the queue is closed.
+func WithDrainOnClose[T any, P Pointer[T]]() Option[T, P] {
+	return func(o *options[T, P]) {
+		o.graceful = true
+	}
+}
+
+// WithStats makes a queue record its statistics in the given Stats, which
+// can be read at any time with its Snapshot method. By default, queues do
+// not record any statistics.
+//
+// It applies to every queue that accepts options except Broadcast, whose elements
+// are popped once per Subscriber, and Batcher, which does not hold on to its
+// elements once they are flushed. Both ignore it.
+func WithStats[T any, P Pointer[T]](stats *Stats) Option[T, P] {
+	return func(o *options[T, P]) {
+		o.stats = stats
+	}
+}
+
+// WithShrinkAfter makes an Unbounded queue halve its capacity once it has been at
+// most a quarter full for the given number of consecutive Pop operations. By default,
+// an Unbounded queue never shrinks.
+func WithShrinkAfter[T any, P Pointer[T]](pops uint64) Option[T, P] {
+	return func(o *options[T, P]) {
+		o.shrinkAfter = pops
+	}
+}
+
+// WithSegmentSize sets the size in bytes a log segment of a Durable queue can grow to
+// before a new segment is started. By default, DefaultSegmentSize is used.
+func WithSegmentSize[T any, P Pointer[T]](size int64) Option[T, P] {
+	return func(o *options[T, P]) {
+		o.segmentSize = size
+	}
+}
+
+// WithSizer sets the function a Budget queue or a Batcher uses to get the cost of an element.
+// By default, the Size method is used if P implements Sizer, and every element costs 1 otherwise.
+//
+// The cost of an element must not change while it is in the queue.
+func WithSizer[T any, P Pointer[T]](sizer func(P) uint64) Option[T, P] {
+	return func(o *options[T, P]) {
+		o.sizer = sizer
+	}
+}
+
+// WithOversizePolicy sets the OversizePolicy a Budget queue applies when an element is
+// pushed that costs more than the whole budget. By default, the OversizeReject policy is used.
+func WithOversizePolicy[T any, P Pointer[T]](policy OversizePolicy) Option[T, P] {
+	return func(o *options[T, P]) {
+		o.oversize = policy
+	}
+}
+
+// WithMaxBytes makes a Batcher flush its batch once the total cost of its elements,
+// as given by Sizer or WithSizer, reaches the given number of bytes. By default, a
+// Batcher only flushes by count and linger time.
+func WithMaxBytes[T any, P Pointer[T]](bytes uint64) Option[T, P] {
+	return func(o *options[T, P]) {
+		o.maxBytes = bytes
+	}
+}
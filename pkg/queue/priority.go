@@ -0,0 +1,279 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Priority is a heap-backed priority queue that pops the element
+// with the highest priority first, according to a user-supplied less function.
+// Elements with the same priority are popped in FIFO order.
+//
+// It is thread safe, Pop blocks the caller if the queue is empty and,
+// if the queue is bounded, Push returns FullError if the queue is full.
+type Priority[T any, P Pointer[T]] struct {
+	_padding0 [8]uint64 //nolint:structcheck,unused
+	heap      *heap[T, P]
+	_padding1 [8]uint64 //nolint:structcheck,unused
+	maxSize   uint64
+	_padding2 [8]uint64 //nolint:structcheck,unused
+	closed    bool
+	_padding3 [8]uint64 //nolint:structcheck,unused
+	lock      *sync.Mutex
+	_padding4 [8]uint64 //nolint:structcheck,unused
+	notEmpty  *sync.Cond
+	_padding5 [8]uint64 //nolint:structcheck,unused
+	graceful  bool
+	_padding6 [8]uint64 //nolint:structcheck,unused
+	stats     *Stats
+}
+
+// NewPriority creates a new priority queue where an element a is popped before an
+// element b if less(a, b) returns true.
+//
+// If maxSize is 0 the queue is unbounded, otherwise Push returns FullError
+// once the queue holds maxSize elements. The WithDrainOnClose option can be
+// used to keep returning the remaining elements from Pop after the queue is closed.
+func NewPriority[T any, P Pointer[T]](less func(a P, b P) bool, maxSize uint64, opts ...Option[T, P]) *Priority[T, P] {
+	q := new(Priority[T, P])
+	o := newOptions(opts)
+	q.graceful = o.graceful
+	q.stats = o.stats
+	q.lock = new(sync.Mutex)
+	q.notEmpty = sync.NewCond(q.lock)
+	q.heap = newHeap(func(a *entry[T, P], b *entry[T, P]) bool {
+		return less(a.value, b.value)
+	})
+	q.maxSize = maxSize
+	return q
+}
+
+// IsEmpty returns true if the queue is empty.
+func (q *Priority[T, P]) IsEmpty() (empty bool) {
+	q.lock.Lock()
+	empty = q.isEmpty()
+	q.lock.Unlock()
+	return
+}
+
+// isEmpty is an internal function used to check if the
+// queue is empty.
+func (q *Priority[T, P]) isEmpty() bool {
+	return q.heap.length() == 0
+}
+
+// IsFull returns true if the queue is full. An unbounded
+// queue is never full.
+func (q *Priority[T, P]) IsFull() (full bool) {
+	q.lock.Lock()
+	full = q.isFull()
+	q.lock.Unlock()
+	return
+}
+
+// isFull is an internal function used to check if the
+// queue is full.
+func (q *Priority[T, P]) isFull() bool {
+	return q.maxSize > 0 && uint64(q.heap.length()) >= q.maxSize
+}
+
+// IsClosed returns true if the queue is Closed
+//
+// The Drain method can be used to drain the queue after it is closed.
+func (q *Priority[T, P]) IsClosed() (closed bool) {
+	q.lock.Lock()
+	closed = q.isClosed()
+	q.lock.Unlock()
+	return
+}
+
+// isClosed is an internal function used to check if the
+// queue is closed.
+func (q *Priority[T, P]) isClosed() bool {
+	return q.closed
+}
+
+// isDone is an internal function used to check if Pop operations
+// should return Closed, which is as soon as the queue is closed, or once
+// it is also empty if it was created with WithDrainOnClose.
+func (q *Priority[T, P]) isDone() bool {
+	return q.closed && (!q.graceful || q.isEmpty())
+}
+
+// Length returns the number of elements in the queue.
+func (q *Priority[T, P]) Length() (size int) {
+	q.lock.Lock()
+	size = q.heap.length()
+	q.lock.Unlock()
+	return
+}
+
+// Close closes the queue permanently.
+//
+// The Drain method can be used to drain the queue after it is closed.
+func (q *Priority[T, P]) Close() {
+	q.lock.Lock()
+	q.closed = true
+	q.notEmpty.Broadcast()
+	q.lock.Unlock()
+}
+
+// Push adds an element to the queue, returning FullError if
+// the queue is bounded and full.
+func (q *Priority[T, P]) Push(p P) error {
+	q.lock.Lock()
+	if q.isClosed() {
+		q.lock.Unlock()
+		return Closed
+	}
+	if q.isFull() {
+		q.lock.Unlock()
+		return FullError
+	}
+	q.heap.push(entry[T, P]{value: p})
+	q.stats.push(1)
+	q.notEmpty.Signal()
+	q.lock.Unlock()
+	return nil
+}
+
+// PushBatch adds as many of the given elements to the queue as there is space for
+// in a single critical section, returning the number of elements that were added.
+//
+// If not all the elements could be added, FullError is returned.
+func (q *Priority[T, P]) PushBatch(ps []P) (n int, err error) {
+	if len(ps) == 0 {
+		return 0, nil
+	}
+	q.lock.Lock()
+	if q.isClosed() {
+		q.lock.Unlock()
+		return 0, Closed
+	}
+	for ; n < len(ps) && !q.isFull(); n++ {
+		q.heap.push(entry[T, P]{value: ps[n]})
+	}
+	q.stats.push(n)
+	if n > 1 {
+		q.notEmpty.Broadcast()
+	} else if n == 1 {
+		q.notEmpty.Signal()
+	}
+	q.lock.Unlock()
+	if n < len(ps) {
+		return n, FullError
+	}
+	return n, nil
+}
+
+// Pop removes the element with the highest priority from the queue,
+// blocking until one is available.
+func (q *Priority[T, P]) Pop() (P, error) {
+	return q.PopContext(context.Background())
+}
+
+// PopContext removes the element with the highest priority from the queue,
+// blocking until one is available.
+//
+// If the context is cancelled while waiting, ctx.Err() is returned.
+func (q *Priority[T, P]) PopContext(ctx context.Context) (p P, err error) {
+	var stop func()
+	var waited time.Time
+	q.lock.Lock()
+LOOP:
+	if q.isDone() {
+		q.lock.Unlock()
+		q.stats.popWaited(waited)
+		if stop != nil {
+			stop()
+		}
+		return nil, Closed
+	}
+	if q.isEmpty() {
+		if err = ctx.Err(); err != nil {
+			q.lock.Unlock()
+			q.stats.popWaited(waited)
+			if stop != nil {
+				stop()
+			}
+			return nil, err
+		}
+		if stop == nil {
+			stop = wakeOnDone(ctx, q.notEmpty)
+		}
+		if waited.IsZero() {
+			waited = q.stats.now()
+		}
+		q.notEmpty.Wait()
+		goto LOOP
+	}
+
+	p = q.heap.pop().value
+	q.stats.pop(1)
+	q.lock.Unlock()
+	q.stats.popWaited(waited)
+	if stop != nil {
+		stop()
+	}
+	return
+}
+
+// PopBatch removes up to max elements (and at most len(dst)) from the queue in
+// priority order and stores them in dst, returning the number of elements that
+// were removed.
+//
+// It blocks until at least one element is available, and then removes as many
+// elements as are available in a single critical section.
+func (q *Priority[T, P]) PopBatch(dst []P, max int) (n int, err error) {
+	if max > len(dst) {
+		max = len(dst)
+	}
+	if max <= 0 {
+		return 0, nil
+	}
+	var waited time.Time
+	q.lock.Lock()
+LOOP:
+	if q.isDone() {
+		q.lock.Unlock()
+		q.stats.popWaited(waited)
+		return 0, Closed
+	}
+	if q.isEmpty() {
+		if waited.IsZero() {
+			waited = q.stats.now()
+		}
+		q.notEmpty.Wait()
+		goto LOOP
+	}
+
+	for ; n < max && !q.isEmpty(); n++ {
+		dst[n] = q.heap.pop().value
+	}
+	q.stats.pop(n)
+	q.lock.Unlock()
+	q.stats.popWaited(waited)
+	return
+}
+
+// Drain removes all elements from the queue
+// and returns them in a slice, in priority order.
+//
+// This function should only be called after the queue is closed.
+func (q *Priority[T, P]) Drain() (values []P) {
+	q.lock.Lock()
+	if q.isEmpty() {
+		q.lock.Unlock()
+		return nil
+	}
+	values = make([]P, 0, q.heap.length())
+	for !q.isEmpty() {
+		values = append(values, q.heap.pop().value)
+	}
+	q.stats.pop(len(values))
+	q.lock.Unlock()
+	return values
+}
@@ -0,0 +1,159 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPriority(t *testing.T) {
+	t.Parallel()
+
+	less := func(a *P, b *P) bool {
+		return a.Int > b.Int
+	}
+	testPacket := func(i int, s string) *P {
+		return &P{Int: i, String: s}
+	}
+
+	t.Run("ordering", func(t *testing.T) {
+		q := NewPriority[P, *P](less, 0)
+		for _, i := range []int{3, 1, 4, 1, 5, 9, 2, 6} {
+			require.NoError(t, q.Push(testPacket(i, "")))
+		}
+		assert.Equal(t, 8, q.Length())
+		var actual []int
+		for !q.IsEmpty() {
+			p, err := q.Pop()
+			require.NoError(t, err)
+			actual = append(actual, p.Int)
+		}
+		assert.Equal(t, []int{9, 6, 5, 4, 3, 2, 1, 1}, actual)
+	})
+	t.Run("equal priorities are fifo", func(t *testing.T) {
+		q := NewPriority[P, *P](less, 0)
+		packets := []*P{testPacket(1, "a"), testPacket(2, "b"), testPacket(1, "c"), testPacket(2, "d"), testPacket(1, "e")}
+		for _, p := range packets {
+			require.NoError(t, q.Push(p))
+		}
+		var actual []string
+		for !q.IsEmpty() {
+			p, err := q.Pop()
+			require.NoError(t, err)
+			actual = append(actual, p.String)
+		}
+		assert.Equal(t, []string{"b", "d", "a", "c", "e"}, actual)
+	})
+	t.Run("bounded", func(t *testing.T) {
+		q := NewPriority[P, *P](less, 2)
+		require.NoError(t, q.Push(testPacket(1, "")))
+		require.NoError(t, q.Push(testPacket(2, "")))
+		assert.True(t, q.IsFull())
+		assert.ErrorIs(t, q.Push(testPacket(3, "")), FullError)
+
+		n, err := q.PushBatch([]*P{testPacket(3, "")})
+		assert.ErrorIs(t, err, FullError)
+		assert.Equal(t, 0, n)
+
+		p, err := q.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, 2, p.Int)
+		assert.False(t, q.IsFull())
+	})
+	t.Run("pop blocks until push", func(t *testing.T) {
+		q := NewPriority[P, *P](less, 0)
+		done := make(chan *P, 1)
+		go func() {
+			p, err := q.Pop()
+			assert.NoError(t, err)
+			done <- p
+		}()
+		select {
+		case <-done:
+			t.Fatal("Priority did not block on empty read")
+		case <-time.After(time.Millisecond * 10):
+		}
+		p := testPacket(1, "")
+		require.NoError(t, q.Push(p))
+		assert.Equal(t, p, <-done)
+	})
+	t.Run("pop context", func(t *testing.T) {
+		q := NewPriority[P, *P](less, 0)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+		defer cancel()
+		_, err := q.PopContext(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+	t.Run("batch", func(t *testing.T) {
+		q := NewPriority[P, *P](less, 0)
+		n, err := q.PushBatch([]*P{testPacket(1, ""), testPacket(3, ""), testPacket(2, "")})
+		require.NoError(t, err)
+		assert.Equal(t, 3, n)
+
+		dst := make([]*P, 2)
+		n, err = q.PopBatch(dst, len(dst))
+		require.NoError(t, err)
+		require.Equal(t, 2, n)
+		assert.Equal(t, 3, dst[0].Int)
+		assert.Equal(t, 2, dst[1].Int)
+	})
+	t.Run("close and drain", func(t *testing.T) {
+		q := NewPriority[P, *P](less, 0)
+		require.NoError(t, q.Push(testPacket(1, "")))
+		require.NoError(t, q.Push(testPacket(2, "")))
+		q.Close()
+		assert.True(t, q.IsClosed())
+		assert.ErrorIs(t, q.Push(testPacket(3, "")), Closed)
+		_, err := q.Pop()
+		assert.ErrorIs(t, err, Closed)
+
+		drained := q.Drain()
+		require.Len(t, drained, 2)
+		assert.Equal(t, 2, drained[0].Int)
+		assert.Equal(t, 1, drained[1].Int)
+		assert.Nil(t, q.Drain())
+	})
+	t.Run("drain on close", func(t *testing.T) {
+		q := NewPriority[P, *P](less, 0, WithDrainOnClose[P, *P]())
+		require.NoError(t, q.Push(testPacket(1, "")))
+		require.NoError(t, q.Push(testPacket(2, "")))
+		q.Close()
+		p, err := q.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, 2, p.Int)
+		p, err = q.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, 1, p.Int)
+		_, err = q.Pop()
+		assert.ErrorIs(t, err, Closed)
+	})
+	t.Run("concurrent", func(t *testing.T) {
+		const producers = 4
+		const items = 512
+		q := NewPriority[P, *P](less, 0)
+		var wg sync.WaitGroup
+		for i := 0; i < producers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < items; j++ {
+					assert.NoError(t, q.Push(testPacket(j, "")))
+				}
+			}()
+		}
+		wg.Wait()
+		previous := items
+		for i := 0; i < producers*items; i++ {
+			p, err := q.Pop()
+			require.NoError(t, err)
+			require.LessOrEqual(t, p.Int, previous)
+			previous = p.Int
+		}
+	})
+}
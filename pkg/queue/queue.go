@@ -3,15 +3,83 @@
 package queue
 
 import (
+	"context"
 	"errors"
+	"sync"
 )
 
 var (
-	Closed     = errors.New("queue is closed")
-	FullError  = errors.New("queue is full")
-	EmptyError = errors.New("queue is empty")
+	Closed        = errors.New("queue is closed")
+	FullError     = errors.New("queue is full")
+	EmptyError    = errors.New("queue is empty")
+	SizeError     = errors.New("queue size is smaller than its length")
+	OversizeError = errors.New("element is larger than the queue budget")
 )
 
+var (
+	_ BlockingQueue[struct{}, *struct{}] = (*Circular[struct{}, *struct{}])(nil)
+	_ BlockingQueue[struct{}, *struct{}] = (*LockFree[struct{}, *struct{}])(nil)
+	_ Queue[struct{}, *struct{}]         = (*NonBlocking[struct{}, *struct{}])(nil)
+	_ Queue[struct{}, *struct{}]         = (*Priority[struct{}, *struct{}])(nil)
+	_ BlockingQueue[struct{}, *struct{}] = (*Unbounded[struct{}, *struct{}])(nil)
+	_ BlockingQueue[struct{}, *struct{}] = (*Sharded[struct{}, *struct{}])(nil)
+	_ BlockingQueue[struct{}, *struct{}] = (*Budget[struct{}, *struct{}])(nil)
+)
+
+// Queue is the set of methods implemented by all the FIFO queues in this package.
+//
+// Push and Pop return Closed once the queue is closed, and the Drain method can
+// be used to retrieve the elements that were still in the queue after it is closed.
+// Queues created with the WithDrainOnClose option instead keep returning the
+// remaining elements from Pop, and only return Closed once they are also empty.
+type Queue[T any, P Pointer[T]] interface {
+	// Push adds an element to the queue.
+	Push(P) error
+
+	// Pop removes an element from the queue.
+	Pop() (P, error)
+
+	// PushBatch adds the given elements to the queue in order, returning
+	// the number of elements that were added.
+	PushBatch([]P) (int, error)
+
+	// PopBatch removes up to the given number of elements from the queue, storing
+	// them in the given slice and returning the number of elements that were removed.
+	PopBatch([]P, int) (int, error)
+
+	// Length returns the number of elements in the queue.
+	Length() int
+
+	// IsEmpty returns true if the queue is empty.
+	IsEmpty() bool
+
+	// IsFull returns true if the queue is full.
+	IsFull() bool
+
+	// IsClosed returns true if the queue is closed.
+	IsClosed() bool
+
+	// Close closes the queue permanently.
+	Close()
+
+	// Drain removes all the elements from the queue and returns them in a slice.
+	Drain() []P
+}
+
+// BlockingQueue is a Queue whose Push and Pop methods block until there is space
+// or an element available, and whose waits can be cancelled with a context.
+type BlockingQueue[T any, P Pointer[T]] interface {
+	Queue[T, P]
+
+	// PushContext adds an element to the queue, returning ctx.Err() if the
+	// context is cancelled while waiting for space.
+	PushContext(context.Context, P) error
+
+	// PopContext removes an element from the queue, returning ctx.Err() if the
+	// context is cancelled while waiting for an element.
+	PopContext(context.Context) (P, error)
+}
+
 // round takes an uint64 value and rounds up to the nearest power of 2
 func round(value uint64) uint64 {
 	value--
@@ -24,3 +92,43 @@ func round(value uint64) uint64 {
 	value++
 	return value
 }
+
+// copyRing copies the elements of a ring buffer between head and tail into dst
+// in FIFO order, returning the number of elements that were copied, which is
+// at most len(dst).
+func copyRing[E any](dst []E, nodes []E, head uint64, tail uint64) int {
+	if head <= tail {
+		return copy(dst, nodes[head:tail])
+	}
+	n := copy(dst, nodes[head:])
+	return n + copy(dst[n:], nodes[:tail])
+}
+
+// wakeOnDone starts a goroutine that broadcasts on the given condition
+// once the context is done, so that waiters can observe the cancellation.
+//
+// The broadcast happens while holding the condition's lock, which guarantees that
+// a waiter either sees ctx.Err() before waiting or is woken up afterwards. Waiters
+// only give up while the condition they are waiting on still does not hold, so no
+// Signal meant for another waiter is ever lost.
+//
+// The returned function must be called to stop the goroutine. It is nil
+// if the context can never be cancelled.
+func wakeOnDone(ctx context.Context, cond *sync.Cond) func() {
+	if ctx.Done() == nil {
+		return nil
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			cond.L.Lock()
+			cond.Broadcast()
+			cond.L.Unlock()
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+	}
+}
@@ -4,10 +4,28 @@ package queue
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
+// numbered returns a new P whose Int is i, so that tests can tell elements apart.
+func numbered(i int) *P {
+	return &P{Int: i}
+}
+
+// receive returns the next value from ch, failing the test with
+// the given message if no value is received within a second.
+func receive[T any](t *testing.T, ch <-chan T, msg string) (v T) {
+	t.Helper()
+	select {
+	case v = <-ch:
+	case <-time.After(time.Second):
+		t.Fatal(msg)
+	}
+	return
+}
+
 func TestRound(t *testing.T) {
 	t.Parallel()
 	tcs := []struct {
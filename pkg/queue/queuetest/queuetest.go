@@ -0,0 +1,499 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package queuetest provides a conformance test suite that can be run against
+// any implementation of the queue.Queue and queue.BlockingQueue interfaces.
+package queuetest
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/common/pkg/queue"
+)
+
+const (
+	// Size is the size that queues are created with by the test suite
+	Size = 8
+
+	producers = 4
+	consumers = 4
+	items     = 1024
+)
+
+// Element is the type of the elements pushed to the queues under test
+type Element struct {
+	Value int
+}
+
+// Factory creates a new, empty queue that can hold at least size elements
+type Factory func(size uint64) queue.Queue[Element, *Element]
+
+// BlockingFactory creates a new, empty blocking queue that can hold at least size elements
+type BlockingFactory func(size uint64) queue.BlockingQueue[Element, *Element]
+
+// elements returns n new elements with increasing values starting from start
+func elements(start int, n int) []*Element {
+	e := make([]*Element, n)
+	for i := range e {
+		e[i] = &Element{Value: start + i}
+	}
+	return e
+}
+
+// drainer returns the DrainTo method of the given queue, or nil if it cannot
+// drain into a slice, looking through the adapters for queues of values.
+func drainer(q queue.Queue[Element, *Element]) func([]*Element) int {
+	switch q := q.(type) {
+	case interface{ DrainTo([]*Element) int }:
+		return q.DrainTo
+	case *values:
+		return q.drainTo()
+	case *blockingValues:
+		return q.drainTo()
+	}
+	return nil
+}
+
+// Run runs the conformance test suite for queue.Queue against the queues
+// created by the given factory.
+func Run(t *testing.T, factory Factory) {
+	run(t, factory, true)
+}
+
+// run runs the conformance test suite for queue.Queue, only checking the order
+// the elements are popped in if ordered is true.
+func run(t *testing.T, factory Factory, ordered bool) {
+	t.Run("fifo", func(t *testing.T) {
+		q := factory(Size)
+		assert.True(t, q.IsEmpty())
+		assert.False(t, q.IsFull())
+
+		next := 0
+		var received []*Element
+		pop := func() {
+			actual, err := q.Pop()
+			require.NoError(t, err)
+			if ordered {
+				require.Equal(t, len(received), actual.Value)
+			}
+			received = append(received, actual)
+		}
+		for round := 0; round < Size*4; round++ {
+			if q.Length()+2 > Size {
+				for !q.IsEmpty() {
+					pop()
+				}
+			}
+			for _, e := range elements(next, 2) {
+				require.NoError(t, q.Push(e))
+			}
+			next += 2
+			pop()
+			require.Equal(t, next-len(received), q.Length())
+		}
+		for !q.IsEmpty() {
+			pop()
+		}
+		assert.ElementsMatch(t, elements(0, next), received)
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		q := factory(Size)
+		e := elements(0, Size)
+		n, err := q.PushBatch(e)
+		require.NoError(t, err)
+		require.Equal(t, Size, n)
+		require.Equal(t, Size, q.Length())
+
+		dst := make([]*Element, 3)
+		var received []*Element
+		for len(received) < Size {
+			n, err = q.PopBatch(dst, len(dst))
+			require.NoError(t, err)
+			require.Greater(t, n, 0)
+			received = append(received, dst[:n]...)
+		}
+		if ordered {
+			assert.Equal(t, e, received)
+		} else {
+			assert.ElementsMatch(t, e, received)
+		}
+		assert.True(t, q.IsEmpty())
+
+		n, err = q.PopBatch(dst, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, n)
+	})
+
+	t.Run("close", func(t *testing.T) {
+		q := factory(Size)
+		assert.False(t, q.IsClosed())
+		q.Close()
+		assert.True(t, q.IsClosed())
+
+		assert.ErrorIs(t, q.Push(&Element{}), queue.Closed)
+		_, err := q.Pop()
+		assert.ErrorIs(t, err, queue.Closed)
+		_, err = q.PushBatch(elements(0, 2))
+		assert.ErrorIs(t, err, queue.Closed)
+		_, err = q.PopBatch(make([]*Element, 2), 2)
+		assert.ErrorIs(t, err, queue.Closed)
+	})
+
+	t.Run("drain", func(t *testing.T) {
+		q := factory(Size)
+		assert.Empty(t, q.Drain())
+
+		// Pushing and popping moves the head past the end of the ring first, by a
+		// different amount every time, so that the drained elements wrap around it.
+		for offset := 0; offset < Size*2; offset++ {
+			q = factory(Size)
+			for i := 0; i < Size*2+offset; i++ {
+				require.NoError(t, q.Push(&Element{Value: -1}))
+				_, err := q.Pop()
+				require.NoError(t, err)
+			}
+			e := elements(0, Size-1)
+			for _, p := range e {
+				require.NoError(t, q.Push(p))
+			}
+			q.Close()
+			if ordered {
+				require.Equal(t, e, q.Drain(), "offset %d", offset)
+			} else {
+				require.ElementsMatch(t, e, q.Drain(), "offset %d", offset)
+			}
+			assert.True(t, q.IsEmpty())
+			assert.Empty(t, q.Drain())
+		}
+	})
+
+	t.Run("drain wrapped", func(t *testing.T) {
+		// Random sequences of pushes and pops move the head and tail around the
+		// queue, and whatever is left is drained either at once or in chunks when
+		// the queue can drain into a slice.
+		property := func(size uint8, ops []bool, chunk uint8) bool {
+			q := factory(uint64(size%16) + 1)
+			drainTo := drainer(q)
+			var model []int
+			// remove removes an element from the model, which must be the oldest one
+			// if ordered is true, and returns false if it is not in the model.
+			remove := func(e *Element) bool {
+				for i, v := range model {
+					if v == e.Value {
+						model = append(model[:i], model[i+1:]...)
+						return true
+					}
+					if ordered {
+						break
+					}
+				}
+				return false
+			}
+			next := 0
+			for _, op := range ops {
+				if op {
+					if q.IsFull() {
+						continue
+					}
+					if q.Push(&Element{Value: next}) != nil {
+						return false
+					}
+					model = append(model, next)
+					next++
+					continue
+				}
+				if len(model) == 0 {
+					if !q.IsEmpty() {
+						return false
+					}
+					continue
+				}
+				e, err := q.Pop()
+				if err != nil || !remove(e) {
+					return false
+				}
+			}
+			if q.Length() != len(model) {
+				return false
+			}
+			var drained []*Element
+			if drainTo == nil || chunk%4 == 0 {
+				drained = q.Drain()
+			} else {
+				dst := make([]*Element, chunk%4)
+				for {
+					n := drainTo(dst)
+					if n == 0 {
+						break
+					}
+					drained = append(drained, dst[:n]...)
+				}
+			}
+			for _, e := range drained {
+				if !remove(e) {
+					return false
+				}
+			}
+			return len(model) == 0 && q.IsEmpty() && len(q.Drain()) == 0
+		}
+		require.NoError(t, quick.Check(property, &quick.Config{MaxCount: 1000}))
+	})
+
+	t.Run("concurrency", func(t *testing.T) {
+		q := factory(Size)
+		var wg sync.WaitGroup
+		for i := 0; i < producers; i++ {
+			wg.Add(1)
+			go func(producer int) {
+				defer wg.Done()
+				for _, e := range elements(producer*items, items) {
+					for {
+						err := q.Push(e)
+						if errors.Is(err, queue.FullError) {
+							runtime.Gosched()
+							continue
+						}
+						assert.NoError(t, err)
+						break
+					}
+				}
+			}(i)
+		}
+
+		results := make(chan []int, consumers)
+		for i := 0; i < consumers; i++ {
+			go func() {
+				var received []int
+				last := make(map[int]int, producers)
+				for {
+					e, err := q.Pop()
+					if errors.Is(err, queue.EmptyError) {
+						runtime.Gosched()
+						continue
+					}
+					if err != nil {
+						assert.ErrorIs(t, err, queue.Closed)
+						results <- received
+						return
+					}
+					producer := e.Value / items
+					if previous, ok := last[producer]; ok && ordered {
+						assert.Greater(t, e.Value, previous, "elements from a producer were reordered")
+					}
+					last[producer] = e.Value
+					received = append(received, e.Value)
+				}
+			}()
+		}
+
+		wg.Wait()
+		for !q.IsEmpty() {
+			time.Sleep(time.Millisecond)
+		}
+		q.Close()
+
+		seen := make([]bool, producers*items)
+		for i := 0; i < consumers; i++ {
+			for _, v := range <-results {
+				require.Falsef(t, seen[v], "element %d received twice", v)
+				seen[v] = true
+			}
+		}
+		for v, ok := range seen {
+			require.Truef(t, ok, "element %d was never received", v)
+		}
+	})
+}
+
+// RunBlocking runs the conformance test suite for queue.BlockingQueue against the
+// queues created by the given factory, which includes the queue.Queue test suite.
+//
+// The queues must become full once they hold enough elements, RunUnbounded must
+// be used instead for queues that grow without bound.
+func RunBlocking(t *testing.T, factory BlockingFactory) {
+	runBlocking(t, factory, true)
+	runFull(t, factory)
+}
+
+// RunRelaxed runs the same conformance test suite as RunBlocking against the queues
+// created by the given factory, without checking the order the elements are popped
+// in, for queues that only guarantee relaxed FIFO ordering like queue.Sharded.
+func RunRelaxed(t *testing.T, factory BlockingFactory) {
+	runBlocking(t, factory, false)
+	runFull(t, factory)
+}
+
+// runFull runs the part of the conformance test suite for queue.BlockingQueue
+// that applies to queues that become full.
+func runFull(t *testing.T, factory BlockingFactory) {
+	t.Run("push blocks when full", func(t *testing.T) {
+		q := factory(Size)
+		for i := 0; !q.IsFull(); i++ {
+			if i > Size*Size {
+				t.Fatal("queue never becomes full")
+			}
+			require.NoError(t, q.Push(&Element{Value: i}))
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+		defer cancel()
+		assert.ErrorIs(t, q.PushContext(ctx, &Element{}), context.DeadlineExceeded)
+
+		done := make(chan error, 1)
+		go func() {
+			done <- q.Push(&Element{})
+		}()
+		select {
+		case <-done:
+			t.Fatal("Push did not block on a full queue")
+		case <-time.After(time.Millisecond * 10):
+		}
+		_, err := q.Pop()
+		require.NoError(t, err)
+		select {
+		case err = <-done:
+			assert.NoError(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("Push did not unblock after a Pop")
+		}
+	})
+}
+
+// RunUnbounded runs the conformance test suite for queue.BlockingQueue against queues
+// created by the given factory that grow without bound, so their Push never blocks.
+func RunUnbounded(t *testing.T, factory BlockingFactory) {
+	runBlocking(t, factory, true)
+
+	t.Run("push never blocks", func(t *testing.T) {
+		q := factory(Size)
+		for i := 0; i < Size*Size; i++ {
+			require.NoError(t, q.Push(&Element{Value: i}))
+		}
+		assert.False(t, q.IsFull())
+		assert.Equal(t, Size*Size, q.Length())
+	})
+}
+
+// runBlocking runs the part of the conformance test suite for queue.BlockingQueue
+// that applies to both bounded and unbounded queues.
+func runBlocking(t *testing.T, factory BlockingFactory, ordered bool) {
+	run(t, func(size uint64) queue.Queue[Element, *Element] {
+		return factory(size)
+	}, ordered)
+
+	t.Run("pop blocks until push", func(t *testing.T) {
+		q := factory(Size)
+		done := make(chan *Element, 1)
+		go func() {
+			e, err := q.Pop()
+			assert.NoError(t, err)
+			done <- e
+		}()
+		select {
+		case <-done:
+			t.Fatal("Pop did not block on an empty queue")
+		case <-time.After(time.Millisecond * 10):
+		}
+		e := &Element{Value: 1}
+		require.NoError(t, q.Push(e))
+		select {
+		case actual := <-done:
+			assert.Equal(t, e, actual)
+		case <-time.After(time.Second):
+			t.Fatal("Pop did not unblock after a Push")
+		}
+	})
+
+	t.Run("pop context", func(t *testing.T) {
+		q := factory(Size)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+		defer cancel()
+		_, err := q.PopContext(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+		e := &Element{Value: 1}
+		require.NoError(t, q.Push(e))
+		actual, err := q.PopContext(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, e, actual)
+	})
+
+	t.Run("close unblocks pop", func(t *testing.T) {
+		q := factory(Size)
+		done := make(chan error, 1)
+		go func() {
+			_, err := q.Pop()
+			done <- err
+		}()
+		time.Sleep(time.Millisecond * 10)
+		q.Close()
+		select {
+		case err := <-done:
+			assert.ErrorIs(t, err, queue.Closed)
+		case <-time.After(time.Second):
+			t.Fatal("Close did not unblock Pop")
+		}
+	})
+}
+
+// RunDrainOnClose runs the conformance test suite for queues that keep returning
+// their remaining elements from Pop after they are closed, against the queues
+// created by the given factory.
+func RunDrainOnClose(t *testing.T, factory Factory) {
+	t.Run("pop after close", func(t *testing.T) {
+		q := factory(Size)
+		e := elements(0, Size/2)
+		for _, p := range e {
+			require.NoError(t, q.Push(p))
+		}
+		q.Close()
+		assert.ErrorIs(t, q.Push(&Element{}), queue.Closed)
+
+		for _, p := range e {
+			actual, err := q.Pop()
+			require.NoError(t, err)
+			assert.Equal(t, p, actual)
+		}
+		_, err := q.Pop()
+		assert.ErrorIs(t, err, queue.Closed)
+	})
+
+	t.Run("pop batch after close", func(t *testing.T) {
+		q := factory(Size)
+		e := elements(0, Size/2)
+		n, err := q.PushBatch(e)
+		require.NoError(t, err)
+		require.Equal(t, len(e), n)
+		q.Close()
+
+		dst := make([]*Element, Size)
+		n, err = q.PopBatch(dst, len(dst))
+		require.NoError(t, err)
+		assert.Equal(t, e, dst[:n])
+		_, err = q.PopBatch(dst, len(dst))
+		assert.ErrorIs(t, err, queue.Closed)
+	})
+
+	t.Run("drain after close", func(t *testing.T) {
+		q := factory(Size)
+		e := elements(0, Size/2)
+		for _, p := range e {
+			require.NoError(t, q.Push(p))
+		}
+		q.Close()
+		actual, err := q.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, e[0], actual)
+		assert.Equal(t, e[1:], q.Drain())
+		_, err = q.Pop()
+		assert.ErrorIs(t, err, queue.Closed)
+	})
+}
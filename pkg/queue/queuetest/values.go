@@ -0,0 +1,128 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queuetest
+
+import (
+	"context"
+
+	"github.com/loopholelabs/common/pkg/queue"
+)
+
+// ValueQueue is a queue that stores its elements by value, like queue.NonBlockingValue
+type ValueQueue interface {
+	Push(Element) error
+	Pop() (Element, error)
+	PushBatch([]Element) (int, error)
+	PopBatch([]Element, int) (int, error)
+	Length() int
+	IsEmpty() bool
+	IsFull() bool
+	IsClosed() bool
+	Close()
+	Drain() []Element
+}
+
+// BlockingValueQueue is a blocking queue that stores its elements by value, like queue.CircularValue
+type BlockingValueQueue interface {
+	ValueQueue
+	PushContext(context.Context, Element) error
+	PopContext(context.Context) (Element, error)
+}
+
+// Values adapts a queue that stores its elements by value to queue.Queue, so that it
+// can be run through the test suite. Elements are copied into and out of the queue,
+// so every element popped from it is a new pointer to a copy of the pushed element.
+func Values(q ValueQueue) queue.Queue[Element, *Element] {
+	return &values{ValueQueue: q}
+}
+
+// BlockingValues adapts a blocking queue that stores its elements by value to
+// queue.BlockingQueue, the same way Values does.
+func BlockingValues(q BlockingValueQueue) queue.BlockingQueue[Element, *Element] {
+	return &blockingValues{values: values{ValueQueue: q}, queue: q}
+}
+
+// values implements queue.Queue on top of a ValueQueue
+type values struct {
+	ValueQueue
+}
+
+func (v *values) Push(p *Element) error {
+	return v.ValueQueue.Push(*p)
+}
+
+func (v *values) Pop() (*Element, error) {
+	e, err := v.ValueQueue.Pop()
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+func (v *values) PushBatch(ps []*Element) (int, error) {
+	es := make([]Element, len(ps))
+	for i, p := range ps {
+		es[i] = *p
+	}
+	return v.ValueQueue.PushBatch(es)
+}
+
+func (v *values) PopBatch(dst []*Element, max int) (int, error) {
+	es := make([]Element, len(dst))
+	n, err := v.ValueQueue.PopBatch(es, max)
+	for i := 0; i < n; i++ {
+		dst[i] = &es[i]
+	}
+	return n, err
+}
+
+func (v *values) Drain() []*Element {
+	return pointers(v.ValueQueue.Drain())
+}
+
+// drainTo returns the DrainTo method of the underlying queue adapted to pointers,
+// or nil if it cannot drain into a slice
+func (v *values) drainTo() func([]*Element) int {
+	q, ok := v.ValueQueue.(interface{ DrainTo([]Element) int })
+	if !ok {
+		return nil
+	}
+	return func(dst []*Element) int {
+		es := make([]Element, len(dst))
+		n := q.DrainTo(es)
+		for i := 0; i < n; i++ {
+			dst[i] = &es[i]
+		}
+		return n
+	}
+}
+
+// blockingValues implements queue.BlockingQueue on top of a BlockingValueQueue
+type blockingValues struct {
+	values
+	queue BlockingValueQueue
+}
+
+func (v *blockingValues) PushContext(ctx context.Context, p *Element) error {
+	return v.queue.PushContext(ctx, *p)
+}
+
+func (v *blockingValues) PopContext(ctx context.Context) (*Element, error) {
+	e, err := v.queue.PopContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// pointers returns pointers to the given elements, or nil if there are none
+func pointers(es []Element) []*Element {
+	if es == nil {
+		return nil
+	}
+	ps := make([]*Element, len(es))
+	for i := range es {
+		ps[i] = &es[i]
+	}
+	return ps
+}
@@ -0,0 +1,77 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+// ring is a growable FIFO ring buffer whose capacity is always a power of 2,
+// that is used by the queues that are not bounded by their number of elements.
+// It usually holds the pointers to the elements of a queue, but can hold
+// any type a queue needs to keep alongside them.
+//
+// It is not thread safe, and must be protected by the lock of the queue using it.
+type ring[E any] struct {
+	head  uint64
+	size  uint64
+	nodes []E
+}
+
+// newRing creates a new ring with the given capacity, rounded up to the nearest power of 2.
+func newRing[E any](capacity uint64) *ring[E] {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &ring[E]{
+		nodes: make([]E, round(capacity)),
+	}
+}
+
+// length returns the number of elements in the ring.
+func (r *ring[E]) length() int {
+	return int(r.size)
+}
+
+// capacity returns the number of elements the ring can hold before it has to grow.
+func (r *ring[E]) capacity() int {
+	return len(r.nodes)
+}
+
+// push adds an element to the end of the ring, doubling its capacity if it is full.
+func (r *ring[E]) push(e E) {
+	if r.size == uint64(len(r.nodes)) {
+		r.resize(uint64(len(r.nodes)) << 1)
+	}
+	r.nodes[(r.head+r.size)&uint64(len(r.nodes)-1)] = e
+	r.size++
+}
+
+// peek returns the element at the start of the ring without removing it,
+// the ring must not be empty.
+func (r *ring[E]) peek() E {
+	return r.nodes[r.head&uint64(len(r.nodes)-1)]
+}
+
+// pop removes the element at the start of the ring, the ring must not be empty.
+func (r *ring[E]) pop() (e E) {
+	var zero E
+	index := r.head & uint64(len(r.nodes)-1)
+	e = r.nodes[index]
+	r.nodes[index] = zero
+	r.head++
+	r.size--
+	return
+}
+
+// resize changes the capacity of the ring to the given capacity, which must be a power
+// of 2 that is at least as large as the number of elements in the ring, preserving
+// the order of the elements.
+func (r *ring[E]) resize(capacity uint64) {
+	nodes := make([]E, capacity)
+	start := r.head & uint64(len(r.nodes)-1)
+	end := start + r.size
+	if end > uint64(len(r.nodes)) {
+		end = uint64(len(r.nodes))
+	}
+	n := copy(nodes, r.nodes[start:end])
+	copy(nodes[n:], r.nodes[:r.size-uint64(n)])
+	r.nodes = nodes
+	r.head = 0
+}
@@ -0,0 +1,460 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// shard is a single shard of a Sharded queue, along with the number of Push and
+// Pop operations that are waiting for it to change, so that an operation on the
+// shard only notifies the WaitStrategy when some other operation is waiting.
+type shard[T any, P Pointer[T]] struct {
+	_padding0 [8]uint64 //nolint:structcheck,unused
+	pushers   uint64
+	_padding1 [8]uint64 //nolint:structcheck,unused
+	poppers   uint64
+	_padding2 [8]uint64 //nolint:structcheck,unused
+	queue     *NonBlocking[T, P]
+}
+
+// Sharded is a queue that spreads its elements across a number of NonBlocking
+// shards, each with its own lock, to reduce contention when many producers and
+// consumers use the same queue at the same time.
+//
+// Producers push into the shard given by their hint, or into a shard picked by a cursor
+// that spreads operations across the shards on a best-effort basis, moving on to the next
+// shard if it is full. Consumers pop from their home shard first, and scan the other
+// shards when it is empty.
+//
+// Sharded only guarantees relaxed FIFO ordering: elements pushed into the same shard
+// are popped in the order they were pushed, but elements in different shards may be
+// popped in any order. With a single shard it behaves like a regular FIFO queue.
+//
+// It is thread safe, Push blocks the caller if every shard is full and Pop blocks
+// the caller if every shard is empty. Blocking operations wait using the WaitStrategy
+// the queue was created with, and the Park WaitStrategy can be used to make idle
+// callers park instead of spinning. A waiting operation registers itself with every
+// shard, and operations only notify the WaitStrategy when the shard they changed has
+// a waiter registered, so that they do not share any state while nobody is waiting.
+type Sharded[T any, P Pointer[T]] struct {
+	_padding0 [8]uint64 //nolint:structcheck,unused
+	next      uint64
+	_padding1 [8]uint64 //nolint:structcheck,unused
+	closed    uint64
+	_padding2 [8]uint64 //nolint:structcheck,unused
+	shards    []*shard[T, P]
+	_padding3 [8]uint64 //nolint:structcheck,unused
+	cursors   sync.Pool
+	_padding4 [8]uint64 //nolint:structcheck,unused
+	wait      WaitStrategy
+	_padding5 [8]uint64 //nolint:structcheck,unused
+	graceful  bool
+	_padding6 [8]uint64 //nolint:structcheck,unused
+	stats     *Stats
+}
+
+// NewSharded creates a new Sharded queue with the given number of shards,
+// each of which can hold shardSize elements.
+//
+// By default Pop returns Closed as soon as the queue is closed, the WithDrainOnClose
+// option can be used to keep returning the remaining elements first. Statistics
+// configured with WithStats include the elements in every shard.
+func NewSharded[T any, P Pointer[T]](shards uint64, shardSize uint64, opts ...Option[T, P]) *Sharded[T, P] {
+	q := new(Sharded[T, P])
+	if shards < 1 {
+		shards = 1
+	}
+	o := newOptions(opts)
+	q.wait = o.wait
+	q.graceful = o.graceful
+	q.stats = o.stats
+	q.shards = make([]*shard[T, P], shards)
+	for i := range q.shards {
+		q.shards[i] = &shard[T, P]{
+			queue: NewNonBlocking[T, P](shardSize, WithDrainOnClose[T, P](), WithStats[T, P](o.stats)),
+		}
+	}
+	q.cursors.New = func() interface{} {
+		cursor := new(uint64)
+		*cursor = atomic.AddUint64(&q.next, 1) - 1
+		return cursor
+	}
+	return q
+}
+
+// Shards returns the number of shards in the queue.
+func (q *Sharded[T, P]) Shards() int {
+	return len(q.shards)
+}
+
+// start returns the shard an operation without a hint should start from.
+//
+// The cursors are kept in a sync.Pool so that concurrent operations do not contend on a
+// shared counter. This only spreads operations across the shards on a best-effort basis:
+// the pool may hand a cursor to another goroutine or drop it at any time, in which case
+// a new cursor starts from the shard after the one the previous new cursor started from.
+func (q *Sharded[T, P]) start() uint64 {
+	cursor := q.cursors.Get().(*uint64)
+	start := *cursor
+	*cursor++
+	q.cursors.Put(cursor)
+	return start
+}
+
+// shard returns the shard at the given offset from the start shard.
+func (q *Sharded[T, P]) shard(start uint64, offset uint64) *shard[T, P] {
+	return q.shards[(start+offset)%uint64(len(q.shards))]
+}
+
+// register adds delta to the number of waiting Push operations of every shard,
+// or to the number of waiting Pop operations if pop is true.
+//
+// An operation registers itself before checking the shards one last time and waiting,
+// and the operations that change a shard check whether anyone is registered after
+// changing it, so either the waiting operation sees the change or it is notified of it.
+func (q *Sharded[T, P]) register(pop bool, delta uint64) {
+	for _, s := range q.shards {
+		if pop {
+			atomic.AddUint64(&s.poppers, delta)
+		} else {
+			atomic.AddUint64(&s.pushers, delta)
+		}
+	}
+}
+
+// notify notifies the WaitStrategy if any operation is registered in the given
+// counter of a shard, which must be its pushers after elements are removed from
+// it and its poppers after elements are added to it.
+func (q *Sharded[T, P]) notify(waiters *uint64) {
+	if atomic.LoadUint64(waiters) > 0 {
+		q.wait.Notify()
+	}
+}
+
+// IsEmpty returns true if every shard is empty.
+func (q *Sharded[T, P]) IsEmpty() bool {
+	for _, s := range q.shards {
+		if !s.queue.IsEmpty() {
+			return false
+		}
+	}
+	return true
+}
+
+// IsFull returns true if every shard is full.
+func (q *Sharded[T, P]) IsFull() bool {
+	for _, s := range q.shards {
+		if !s.queue.IsFull() {
+			return false
+		}
+	}
+	return true
+}
+
+// IsClosed returns true if the queue is Closed
+//
+// The Drain method can be used to drain the queue after it is closed.
+func (q *Sharded[T, P]) IsClosed() bool {
+	return atomic.LoadUint64(&q.closed) == 1
+}
+
+// isDone returns whether Pop operations should return Closed, which is as soon as the
+// queue is closed, or once it is also empty if it was created with WithDrainOnClose.
+func (q *Sharded[T, P]) isDone() bool {
+	return atomic.LoadUint64(&q.closed) == 1 && (!q.graceful || q.IsEmpty())
+}
+
+// Length returns the number of elements in all the shards.
+//
+// The shards are not locked at the same time, so the length is
+// only approximate while the queue is being used.
+func (q *Sharded[T, P]) Length() (size int) {
+	for _, s := range q.shards {
+		size += s.queue.Length()
+	}
+	return
+}
+
+// Close closes the queue permanently, returns any waiting Push
+// or Pop calls, and makes all future Push calls return Closed.
+//
+// The Drain method can be used to drain the queue after it is closed.
+func (q *Sharded[T, P]) Close() {
+	if atomic.CompareAndSwapUint64(&q.closed, 0, 1) {
+		for _, s := range q.shards {
+			s.queue.Close()
+		}
+		q.wait.Notify()
+	}
+}
+
+// Push adds an element to the next shard picked by the cursor,
+// blocking until space is available in any shard.
+func (q *Sharded[T, P]) Push(p P) error {
+	return q.push(context.Background(), q.start(), p)
+}
+
+// PushContext adds an element to the next shard picked by the cursor, blocking
+// until space is available in any shard.
+//
+// If the context is cancelled while waiting, ctx.Err() is returned and the
+// element is not added to the queue.
+func (q *Sharded[T, P]) PushContext(ctx context.Context, p P) error {
+	return q.push(ctx, q.start(), p)
+}
+
+// PushHint adds an element to the shard given by the hint (modulo the number of
+// shards), or to the next shard with space if it is full, blocking until space is
+// available in any shard.
+//
+// Elements pushed with the same hint are popped in the order they were pushed,
+// as long as their shard never becomes full.
+func (q *Sharded[T, P]) PushHint(hint uint64, p P) error {
+	return q.push(context.Background(), hint, p)
+}
+
+// push is an internal function used to add an element to the first
+// shard with space, starting from the given shard.
+func (q *Sharded[T, P]) push(ctx context.Context, start uint64, p P) error {
+	var stop func()
+	var waited time.Time
+	var registered bool
+	defer func() {
+		if registered {
+			q.register(false, ^uint64(0))
+		}
+		q.stats.pushWaited(waited)
+		if stop != nil {
+			stop()
+		}
+	}()
+	var iteration uint64
+	for {
+		epoch := q.wait.Epoch()
+		if atomic.LoadUint64(&q.closed) == 1 {
+			return Closed
+		}
+		for i := uint64(0); i < uint64(len(q.shards)); i++ {
+			s := q.shard(start, i)
+			switch err := s.queue.Push(p); err {
+			case nil:
+				q.notify(&s.poppers)
+				return nil
+			case Closed:
+				return Closed
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !registered {
+			registered = true
+			q.register(false, 1)
+			continue
+		}
+		if stop == nil {
+			stop = notifyOnDone(ctx, q.wait)
+		}
+		if waited.IsZero() {
+			waited = q.stats.now()
+		}
+		q.wait.Wait(iteration, epoch)
+		iteration++
+	}
+}
+
+// Pop removes an element from the next shard picked by the cursor, or from the
+// first other shard that is not empty, blocking until one is available.
+func (q *Sharded[T, P]) Pop() (P, error) {
+	return q.pop(context.Background(), q.start())
+}
+
+// PopContext removes an element from the next shard picked by the cursor, or from
+// the first other shard that is not empty, blocking until one is available.
+//
+// If the context is cancelled while waiting, ctx.Err() is returned.
+func (q *Sharded[T, P]) PopContext(ctx context.Context) (P, error) {
+	return q.pop(ctx, q.start())
+}
+
+// PopHint removes an element from the home shard given by the hint (modulo the
+// number of shards), or from the first other shard that is not empty if the home
+// shard is empty, blocking until one is available.
+func (q *Sharded[T, P]) PopHint(hint uint64) (P, error) {
+	return q.pop(context.Background(), hint)
+}
+
+// pop is an internal function used to remove an element from the
+// first shard that is not empty, starting from the given shard.
+func (q *Sharded[T, P]) pop(ctx context.Context, start uint64) (P, error) {
+	var stop func()
+	var waited time.Time
+	var registered bool
+	defer func() {
+		if registered {
+			q.register(true, ^uint64(0))
+		}
+		q.stats.popWaited(waited)
+		if stop != nil {
+			stop()
+		}
+	}()
+	var iteration uint64
+	for {
+		epoch := q.wait.Epoch()
+		if q.isDone() {
+			return nil, Closed
+		}
+		for i := uint64(0); i < uint64(len(q.shards)); i++ {
+			s := q.shard(start, i)
+			if p, err := s.queue.Pop(); err == nil {
+				q.notify(&s.pushers)
+				return p, nil
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if !registered {
+			registered = true
+			q.register(true, 1)
+			continue
+		}
+		if stop == nil {
+			stop = notifyOnDone(ctx, q.wait)
+		}
+		if waited.IsZero() {
+			waited = q.stats.now()
+		}
+		q.wait.Wait(iteration, epoch)
+		iteration++
+	}
+}
+
+// PushBatch adds all the given elements to the queue in order, returning the
+// number of elements that were added.
+//
+// As many elements as there is space for are added to the next shard picked by the
+// cursor in a single critical section, and the rest spill over into the following shards.
+// If every shard is full PushBatch blocks until more space is available.
+func (q *Sharded[T, P]) PushBatch(ps []P) (n int, err error) {
+	if len(ps) == 0 {
+		return 0, nil
+	}
+	start := q.start()
+	var waited time.Time
+	var registered bool
+	defer func() {
+		if registered {
+			q.register(false, ^uint64(0))
+		}
+		q.stats.pushWaited(waited)
+	}()
+	var iteration uint64
+	for {
+		epoch := q.wait.Epoch()
+		if atomic.LoadUint64(&q.closed) == 1 {
+			return n, Closed
+		}
+		for i := uint64(0); i < uint64(len(q.shards)) && n < len(ps); i++ {
+			s := q.shard(start, i)
+			count, err := s.queue.PushBatch(ps[n:])
+			n += count
+			if count > 0 {
+				q.notify(&s.poppers)
+			}
+			if err == Closed {
+				return n, Closed
+			}
+		}
+		if n == len(ps) {
+			return n, nil
+		}
+		if !registered {
+			registered = true
+			q.register(false, 1)
+			continue
+		}
+		if waited.IsZero() {
+			waited = q.stats.now()
+		}
+		q.wait.Wait(iteration, epoch)
+		iteration++
+	}
+}
+
+// PopBatch removes up to max elements (and at most len(dst)) from the queue, starting
+// from the next shard picked by the cursor and moving on to the following shards, and
+// stores them in dst, returning the number of elements that were removed.
+//
+// It blocks until at least one element is available.
+func (q *Sharded[T, P]) PopBatch(dst []P, max int) (n int, err error) {
+	if max > len(dst) {
+		max = len(dst)
+	}
+	if max <= 0 {
+		return 0, nil
+	}
+	start := q.start()
+	var waited time.Time
+	var registered bool
+	defer func() {
+		if registered {
+			q.register(true, ^uint64(0))
+		}
+		q.stats.popWaited(waited)
+	}()
+	var iteration uint64
+	for {
+		epoch := q.wait.Epoch()
+		if q.isDone() {
+			return 0, Closed
+		}
+		for i := uint64(0); i < uint64(len(q.shards)) && n < max; i++ {
+			s := q.shard(start, i)
+			count, _ := s.queue.PopBatch(dst[n:], max-n)
+			n += count
+			if count > 0 {
+				q.notify(&s.pushers)
+			}
+		}
+		if n > 0 {
+			return n, nil
+		}
+		if !registered {
+			registered = true
+			q.register(true, 1)
+			continue
+		}
+		if waited.IsZero() {
+			waited = q.stats.now()
+		}
+		q.wait.Wait(iteration, epoch)
+		iteration++
+	}
+}
+
+// Drain removes all elements from every shard and returns them in a slice,
+// in shard order.
+//
+// This function should only be called after the queue is closed.
+func (q *Sharded[T, P]) Drain() (values []P) {
+	for _, s := range q.shards {
+		drained := len(values)
+		for {
+			p, err := s.queue.Pop()
+			if err != nil {
+				break
+			}
+			values = append(values, p)
+		}
+		if len(values) > drained {
+			q.notify(&s.pushers)
+		}
+	}
+	return values
+}
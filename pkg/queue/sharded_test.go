@@ -0,0 +1,193 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingPark is a Park WaitStrategy that counts how many times it is notified.
+type countingPark struct {
+	*Park
+	notified uint64
+}
+
+func (c *countingPark) Notify() {
+	atomic.AddUint64(&c.notified, 1)
+	c.Park.Notify()
+}
+
+func TestSharded(t *testing.T) {
+	t.Parallel()
+
+	t.Run("hint", func(t *testing.T) {
+		rb := NewSharded[P, *P](4, 4)
+		assert.Equal(t, 4, rb.Shards())
+		for i := 0; i < 3; i++ {
+			require.NoError(t, rb.PushHint(1, numbered(i)))
+		}
+		require.NoError(t, rb.PushHint(2, numbered(3)))
+		assert.Equal(t, 3, rb.shards[1].queue.Length())
+		assert.Equal(t, 1, rb.shards[2].queue.Length())
+		assert.Equal(t, 4, rb.Length())
+
+		p, err := rb.PopHint(2)
+		require.NoError(t, err)
+		assert.Equal(t, 3, p.Int)
+		for i := 0; i < 3; i++ {
+			p, err = rb.PopHint(2)
+			require.NoError(t, err)
+			assert.Equal(t, i, p.Int)
+		}
+		assert.True(t, rb.IsEmpty())
+	})
+	t.Run("spill over", func(t *testing.T) {
+		rb := NewSharded[P, *P](2, 1)
+		require.NoError(t, rb.PushHint(0, numbered(0)))
+		require.NoError(t, rb.PushHint(0, numbered(1)))
+		assert.True(t, rb.IsFull())
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+		defer cancel()
+		assert.ErrorIs(t, rb.PushContext(ctx, numbered(2)), context.DeadlineExceeded)
+
+		doneCh := make(chan error, 1)
+		go func() {
+			doneCh <- rb.Push(numbered(2))
+		}()
+		select {
+		case <-doneCh:
+			t.Fatal("Sharded did not block on full write")
+		case <-time.After(time.Millisecond * 10):
+		}
+		p, err := rb.PopHint(1)
+		require.NoError(t, err)
+		assert.Equal(t, 1, p.Int)
+		require.NoError(t, receive(t, doneCh, "Sharded did not unblock on pop"))
+	})
+	t.Run("batch", func(t *testing.T) {
+		rb := NewSharded[P, *P](3, 3)
+		packets := make([]*P, 9)
+		for i := range packets {
+			packets[i] = numbered(i)
+		}
+		n, err := rb.PushBatch(packets)
+		require.NoError(t, err)
+		assert.Equal(t, 9, n)
+		assert.True(t, rb.IsFull())
+
+		dst := make([]*P, 10)
+		n, err = rb.PopBatch(dst, 10)
+		require.NoError(t, err)
+		assert.Equal(t, 9, n)
+		actual := make([]int, n)
+		for i, p := range dst[:n] {
+			actual[i] = p.Int
+		}
+		sort.Ints(actual)
+		assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8}, actual)
+	})
+	t.Run("close", func(t *testing.T) {
+		rb := NewSharded[P, *P](2, 2)
+		require.NoError(t, rb.PushHint(0, numbered(0)))
+		require.NoError(t, rb.PushHint(1, numbered(1)))
+		rb.Close()
+		assert.True(t, rb.IsClosed())
+		assert.ErrorIs(t, rb.Push(numbered(2)), Closed)
+		_, err := rb.Pop()
+		assert.ErrorIs(t, err, Closed)
+		drained := rb.Drain()
+		require.Len(t, drained, 2)
+		assert.Equal(t, 0, drained[0].Int)
+		assert.Equal(t, 1, drained[1].Int)
+	})
+	t.Run("close unblocks pop", func(t *testing.T) {
+		rb := NewSharded[P, *P](2, 2, WithWaitStrategy[P, *P](NewPark(0)))
+		doneCh := make(chan error, 1)
+		go func() {
+			_, err := rb.Pop()
+			doneCh <- err
+		}()
+		time.Sleep(time.Millisecond * 10)
+		rb.Close()
+		assert.ErrorIs(t, receive(t, doneCh, "Sharded did not unblock on close"), Closed)
+	})
+	t.Run("concurrency", func(t *testing.T) {
+		const workers = 4
+		const count = 1000
+		rb := NewSharded[P, *P](workers, 8, WithWaitStrategy[P, *P](NewPark(16)), WithDrainOnClose[P, *P]())
+
+		var producers sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			producers.Add(1)
+			go func(producer int) {
+				defer producers.Done()
+				for j := 0; j < count; j++ {
+					assert.NoError(t, rb.PushHint(uint64(producer), numbered(producer*count+j)))
+				}
+			}(i)
+		}
+		go func() {
+			producers.Wait()
+			rb.Close()
+		}()
+
+		var lock sync.Mutex
+		seen := make(map[int]struct{})
+		var consumers sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			consumers.Add(1)
+			go func(consumer int) {
+				defer consumers.Done()
+				for {
+					p, err := rb.PopHint(uint64(consumer))
+					if err != nil {
+						assert.ErrorIs(t, err, Closed)
+						return
+					}
+					lock.Lock()
+					seen[p.Int] = struct{}{}
+					lock.Unlock()
+				}
+			}(i)
+		}
+		consumers.Wait()
+		assert.Len(t, seen, workers*count)
+	})
+	t.Run("notify waiters only", func(t *testing.T) {
+		wait := &countingPark{Park: NewPark(0)}
+		rb := NewSharded[P, *P](4, 1, WithWaitStrategy[P, *P](wait))
+		for i := 0; i < 4; i++ {
+			require.NoError(t, rb.Push(numbered(i)))
+		}
+		dst := make([]*P, 4)
+		n, err := rb.PopBatch(dst, 4)
+		require.NoError(t, err)
+		assert.Equal(t, 4, n)
+		assert.Zero(t, atomic.LoadUint64(&wait.notified))
+
+		doneCh := make(chan *P, 1)
+		go func() {
+			p, err := rb.Pop()
+			assert.NoError(t, err)
+			doneCh <- p
+		}()
+		for atomic.LoadUint64(&rb.shards[3].poppers) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		require.NoError(t, rb.PushHint(3, numbered(4)))
+		assert.Equal(t, 4, receive(t, doneCh, "Sharded did not unblock on push").Int)
+		assert.NotZero(t, atomic.LoadUint64(&wait.notified))
+		for _, s := range rb.shards {
+			assert.Zero(t, atomic.LoadUint64(&s.poppers))
+		}
+	})
+}
@@ -0,0 +1,293 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// SPSC is a bounded FIFO queue for exactly one producer and one consumer.
+//
+// The producer only ever writes the head and the consumer only ever writes the tail,
+// so Push and Pop never need a lock or a CAS, just atomic loads and stores. Each side
+// also caches the last index it loaded from the other side, and only reloads it when
+// the queue looks full (or empty), which keeps the two sides from contending on the
+// same cache line.
+//
+// Push, TryPush and PushContext must only be called by one goroutine at a time, and so
+// must Pop, TryPop, PopContext and Drain. All the other methods are safe to call from any
+// goroutine. Blocking operations wait using the WaitStrategy the SPSC was created with,
+// which each side only notifies while the other side is waiting.
+type SPSC[T any, P Pointer[T]] struct {
+	_padding0  [8]uint64 //nolint:structcheck,unused
+	head       uint64
+	_padding1  [8]uint64 //nolint:structcheck,unused
+	cachedTail uint64
+	_padding2  [8]uint64 //nolint:structcheck,unused
+	tail       uint64
+	_padding3  [8]uint64 //nolint:structcheck,unused
+	cachedHead uint64
+	_padding4  [8]uint64 //nolint:structcheck,unused
+	mask       uint64
+	_padding5  [8]uint64 //nolint:structcheck,unused
+	closed     uint64
+	_padding6  [8]uint64 //nolint:structcheck,unused
+	nodes      []P
+	_padding7  [8]uint64 //nolint:structcheck,unused
+	wait       WaitStrategy
+	_padding8  [8]uint64 //nolint:structcheck,unused
+	graceful   bool
+	_padding9  [8]uint64 //nolint:structcheck,unused
+	stats      *Stats
+	_padding10 [8]uint64 //nolint:structcheck,unused
+	pushers    uint64
+	_padding11 [8]uint64 //nolint:structcheck,unused
+	poppers    uint64
+}
+
+// NewSPSC creates a new SPSC that can hold the given number of elements,
+// rounded up to the nearest power of 2.
+//
+// By default blocking operations use the Yield WaitStrategy, the WithWaitStrategy
+// option can be used to change it. The WithDrainOnClose option can be used to keep
+// returning the remaining elements from Pop after the SPSC is closed.
+func NewSPSC[T any, P Pointer[T]](size uint64, opts ...Option[T, P]) *SPSC[T, P] {
+	q := new(SPSC[T, P])
+	if size < 1 {
+		size = 1
+	}
+	o := newOptions(opts)
+	q.wait = o.wait
+	q.graceful = o.graceful
+	q.stats = o.stats
+	size = round(size)
+	q.nodes = make([]P, size)
+	q.mask = size - 1
+	return q
+}
+
+// push is an internal function used by the producer to add an element
+// to the SPSC, returning false if the SPSC is full.
+func (q *SPSC[T, P]) push(p P) bool {
+	head := q.head
+	if head-q.cachedTail > q.mask {
+		q.cachedTail = atomic.LoadUint64(&q.tail)
+		if head-q.cachedTail > q.mask {
+			return false
+		}
+	}
+	q.nodes[head&q.mask] = p
+	atomic.StoreUint64(&q.head, head+1)
+	q.stats.push(1)
+	return true
+}
+
+// pop is an internal function used by the consumer to remove an element
+// from the SPSC, returning false if the SPSC is empty.
+func (q *SPSC[T, P]) pop() (p P, ok bool) {
+	tail := q.tail
+	if tail == q.cachedHead {
+		q.cachedHead = atomic.LoadUint64(&q.head)
+		if tail == q.cachedHead {
+			return nil, false
+		}
+	}
+	index := tail & q.mask
+	p = q.nodes[index]
+	q.nodes[index] = nil
+	atomic.StoreUint64(&q.tail, tail+1)
+	q.stats.pop(1)
+	return p, true
+}
+
+// TryPush adds an element to the SPSC without blocking, returning
+// FullError if the SPSC is full.
+func (q *SPSC[T, P]) TryPush(p P) error {
+	if atomic.LoadUint64(&q.closed) == 1 {
+		return Closed
+	}
+	if !q.push(p) {
+		return FullError
+	}
+	q.notify(&q.poppers)
+	return nil
+}
+
+// Push adds an element to the SPSC, blocking until space is available.
+func (q *SPSC[T, P]) Push(p P) error {
+	return q.PushContext(context.Background(), p)
+}
+
+// PushContext adds an element to the SPSC, blocking until space is available.
+//
+// If the context is cancelled while waiting, ctx.Err() is returned and the
+// element is not added to the SPSC.
+func (q *SPSC[T, P]) PushContext(ctx context.Context, p P) error {
+	if atomic.LoadUint64(&q.closed) == 1 {
+		return Closed
+	}
+	if q.push(p) {
+		q.notify(&q.poppers)
+		return nil
+	}
+
+	var stop func()
+	waited := q.stats.now()
+	atomic.AddUint64(&q.pushers, 1)
+	defer func() {
+		atomic.AddUint64(&q.pushers, ^uint64(0))
+		q.stats.pushWaited(waited)
+		if stop != nil {
+			stop()
+		}
+	}()
+	var iteration uint64
+	for {
+		epoch := q.wait.Epoch()
+		if atomic.LoadUint64(&q.closed) == 1 {
+			return Closed
+		}
+		if q.push(p) {
+			q.notify(&q.poppers)
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if stop == nil {
+			stop = notifyOnDone(ctx, q.wait)
+		}
+		q.wait.Wait(iteration, epoch)
+		iteration++
+	}
+}
+
+// TryPop removes an element from the SPSC without blocking, returning
+// EmptyError if the SPSC is empty.
+func (q *SPSC[T, P]) TryPop() (P, error) {
+	if q.isDone() {
+		return nil, Closed
+	}
+	p, ok := q.pop()
+	if !ok {
+		return nil, EmptyError
+	}
+	q.notify(&q.pushers)
+	return p, nil
+}
+
+// Pop removes an element from the SPSC, blocking until one is available.
+func (q *SPSC[T, P]) Pop() (P, error) {
+	return q.PopContext(context.Background())
+}
+
+// PopContext removes an element from the SPSC, blocking until one is available.
+//
+// If the context is cancelled while waiting, ctx.Err() is returned.
+func (q *SPSC[T, P]) PopContext(ctx context.Context) (P, error) {
+	if q.isDone() {
+		return nil, Closed
+	}
+	if p, ok := q.pop(); ok {
+		q.notify(&q.pushers)
+		return p, nil
+	}
+
+	var stop func()
+	waited := q.stats.now()
+	atomic.AddUint64(&q.poppers, 1)
+	defer func() {
+		atomic.AddUint64(&q.poppers, ^uint64(0))
+		q.stats.popWaited(waited)
+		if stop != nil {
+			stop()
+		}
+	}()
+	var iteration uint64
+	for {
+		epoch := q.wait.Epoch()
+		if q.isDone() {
+			return nil, Closed
+		}
+		if p, ok := q.pop(); ok {
+			q.notify(&q.pushers)
+			return p, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if stop == nil {
+			stop = notifyOnDone(ctx, q.wait)
+		}
+		q.wait.Wait(iteration, epoch)
+		iteration++
+	}
+}
+
+// notify is an internal function used by one side to notify the WaitStrategy after
+// changing the SPSC, if the other side is registered in the given counter as waiting.
+//
+// A side registers itself before it checks the SPSC one last time and waits, so either
+// it sees the change or the change sees that it is registered.
+func (q *SPSC[T, P]) notify(waiters *uint64) {
+	if atomic.LoadUint64(waiters) > 0 {
+		q.wait.Notify()
+	}
+}
+
+// Close marks the SPSC as closed, returns any waiting Pop or Push calls,
+// and makes all future Push calls return Closed.
+func (q *SPSC[T, P]) Close() {
+	if atomic.CompareAndSwapUint64(&q.closed, 0, 1) {
+		q.wait.Notify()
+	}
+}
+
+// isDone returns whether Pop operations should return Closed, which is as soon as the
+// SPSC is closed, or once it is also empty if it was created with WithDrainOnClose.
+func (q *SPSC[T, P]) isDone() bool {
+	return atomic.LoadUint64(&q.closed) == 1 && (!q.graceful || q.Length() == 0)
+}
+
+// IsEmpty returns whether the SPSC is currently empty
+func (q *SPSC[T, P]) IsEmpty() bool {
+	return q.Length() == 0
+}
+
+// IsFull returns whether the SPSC is currently full
+func (q *SPSC[T, P]) IsFull() bool {
+	return uint64(q.Length()) > q.mask
+}
+
+// IsClosed returns whether the SPSC has been closed
+func (q *SPSC[T, P]) IsClosed() bool {
+	return atomic.LoadUint64(&q.closed) == 1
+}
+
+// Length is the current number of elements in the SPSC
+//
+// The tail is loaded before the head, so that a concurrent Pop can never make the
+// length appear negative.
+func (q *SPSC[T, P]) Length() int {
+	tail := atomic.LoadUint64(&q.tail)
+	return int(atomic.LoadUint64(&q.head) - tail)
+}
+
+// Drain removes all the elements from the SPSC and returns them in a slice.
+//
+// It is a consumer operation, so it must not be called concurrently with Pop,
+// and is meant to be used after the SPSC has been closed.
+func (q *SPSC[T, P]) Drain() (values []P) {
+	for {
+		p, ok := q.pop()
+		if !ok {
+			break
+		}
+		values = append(values, p)
+	}
+	if len(values) > 0 {
+		q.notify(&q.pushers)
+	}
+	return values
+}
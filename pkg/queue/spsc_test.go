@@ -0,0 +1,171 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSPSC(t *testing.T) {
+	t.Parallel()
+
+	testPacket := func() *P {
+		return new(P)
+	}
+
+	t.Run("success", func(t *testing.T) {
+		rb := NewSPSC[P, *P](1)
+		p := testPacket()
+		require.NoError(t, rb.Push(p))
+		actual, err := rb.Pop()
+		require.NoError(t, err)
+		assert.Same(t, p, actual)
+	})
+	t.Run("try push and pop", func(t *testing.T) {
+		rb := NewSPSC[P, *P](3)
+		_, err := rb.TryPop()
+		assert.ErrorIs(t, err, EmptyError)
+		packets := make([]*P, 4)
+		for i := range packets {
+			packets[i] = testPacket()
+			packets[i].Int = i
+			require.NoError(t, rb.TryPush(packets[i]))
+		}
+		assert.True(t, rb.IsFull())
+		assert.ErrorIs(t, rb.TryPush(testPacket()), FullError)
+		assert.Equal(t, 4, rb.Length())
+		for _, p := range packets {
+			actual, err := rb.TryPop()
+			require.NoError(t, err)
+			assert.Same(t, p, actual)
+		}
+		assert.True(t, rb.IsEmpty())
+	})
+	t.Run("buffer closed", func(t *testing.T) {
+		rb := NewSPSC[P, *P](1)
+		require.NoError(t, rb.Push(testPacket()))
+		assert.False(t, rb.IsClosed())
+		rb.Close()
+		assert.True(t, rb.IsClosed())
+		assert.ErrorIs(t, rb.Push(testPacket()), Closed)
+		assert.ErrorIs(t, rb.TryPush(testPacket()), Closed)
+		_, err := rb.Pop()
+		assert.ErrorIs(t, err, Closed)
+		assert.Len(t, rb.Drain(), 1)
+	})
+	t.Run("drain on close", func(t *testing.T) {
+		rb := NewSPSC[P, *P](2, WithDrainOnClose[P, *P]())
+		p := testPacket()
+		require.NoError(t, rb.Push(p))
+		rb.Close()
+		actual, err := rb.Pop()
+		require.NoError(t, err)
+		assert.Same(t, p, actual)
+		_, err = rb.TryPop()
+		assert.ErrorIs(t, err, Closed)
+	})
+	t.Run("pop blocks until push", func(t *testing.T) {
+		rb := NewSPSC[P, *P](1, WithWaitStrategy[P, *P](NewPark(0)))
+		p := testPacket()
+		doneCh := make(chan *P, 1)
+		go func() {
+			actual, err := rb.Pop()
+			assert.NoError(t, err)
+			doneCh <- actual
+		}()
+		select {
+		case <-doneCh:
+			t.Fatal("SPSC did not block on empty read")
+		case <-time.After(time.Millisecond * 10):
+		}
+		require.NoError(t, rb.Push(p))
+		select {
+		case actual := <-doneCh:
+			assert.Same(t, p, actual)
+		case <-time.After(time.Second):
+			t.Fatal("SPSC did not unblock on push")
+		}
+	})
+	t.Run("context cancelled", func(t *testing.T) {
+		rb := NewSPSC[P, *P](1, WithWaitStrategy[P, *P](NewPark(0)))
+		require.NoError(t, rb.Push(testPacket()))
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+		defer cancel()
+		assert.ErrorIs(t, rb.PushContext(ctx, testPacket()), context.DeadlineExceeded)
+		assert.Equal(t, 1, rb.Length())
+
+		_, err := rb.Pop()
+		require.NoError(t, err)
+		ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*10)
+		defer cancel()
+		_, err = rb.PopContext(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+	t.Run("close unblocks pop", func(t *testing.T) {
+		rb := NewSPSC[P, *P](1, WithWaitStrategy[P, *P](NewPark(0)))
+		doneCh := make(chan error, 1)
+		go func() {
+			_, err := rb.Pop()
+			doneCh <- err
+		}()
+		time.Sleep(time.Millisecond * 10)
+		rb.Close()
+		select {
+		case err := <-doneCh:
+			assert.ErrorIs(t, err, Closed)
+		case <-time.After(time.Second):
+			t.Fatal("SPSC did not unblock on close")
+		}
+	})
+	t.Run("ordering", func(t *testing.T) {
+		const count = 10000
+		rb := NewSPSC[P, *P](16, WithWaitStrategy[P, *P](NewPark(16)), WithDrainOnClose[P, *P]())
+		go func() {
+			for i := 0; i < count; i++ {
+				p := testPacket()
+				p.Int = i
+				if err := rb.Push(p); err != nil {
+					return
+				}
+			}
+			rb.Close()
+		}()
+		for i := 0; i < count; i++ {
+			actual, err := rb.Pop()
+			require.NoError(t, err)
+			require.Equal(t, i, actual.Int)
+		}
+		_, err := rb.Pop()
+		assert.ErrorIs(t, err, Closed)
+	})
+	t.Run("notify waiters only", func(t *testing.T) {
+		wait := &countingPark{Park: NewPark(0)}
+		rb := NewSPSC[P, *P](1, WithWaitStrategy[P, *P](wait))
+		p := testPacket()
+		require.NoError(t, rb.Push(p))
+		_, err := rb.Pop()
+		require.NoError(t, err)
+		require.NoError(t, rb.TryPush(p))
+		assert.Zero(t, atomic.LoadUint64(&wait.notified))
+
+		doneCh := make(chan error, 1)
+		go func() {
+			doneCh <- rb.Push(testPacket())
+		}()
+		for atomic.LoadUint64(&rb.pushers) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		actual, err := rb.Pop()
+		require.NoError(t, err)
+		assert.Same(t, p, actual)
+		assert.NoError(t, receive(t, doneCh, "SPSC did not unblock on pop"))
+		assert.NotZero(t, atomic.LoadUint64(&wait.notified))
+		assert.Zero(t, atomic.LoadUint64(&rb.pushers))
+	})
+}
@@ -0,0 +1,155 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats collects statistics about the queues it is attached to with the WithStats option.
+//
+// All of its counters are updated atomically, so it is safe to call Snapshot while the
+// queue is being used. Queues created without the WithStats option hold a nil *Stats,
+// whose methods do nothing, so they only pay for a nil check.
+type Stats struct {
+	_padding0 [8]uint64 //nolint:structcheck,unused
+	pushes    uint64
+	_padding1 [8]uint64 //nolint:structcheck,unused
+	pops      uint64
+	_padding2 [8]uint64 //nolint:structcheck,unused
+	drops     uint64
+	_padding3 [8]uint64 //nolint:structcheck,unused
+	depth     int64
+	_padding4 [8]uint64 //nolint:structcheck,unused
+	peak      int64
+	_padding5 [8]uint64 //nolint:structcheck,unused
+	pushWait  int64
+	_padding6 [8]uint64 //nolint:structcheck,unused
+	popWait   int64
+}
+
+// Snapshot is a point-in-time copy of the statistics collected by a Stats.
+type Snapshot struct {
+	// Pushes is the number of elements that were added to the queue.
+	Pushes uint64
+
+	// Pops is the number of elements that were removed from the queue,
+	// including the ones returned by Drain.
+	Pops uint64
+
+	// Drops is the number of elements that were dropped or evicted instead
+	// of being returned by the queue.
+	Drops uint64
+
+	// Depth is the current number of elements in the queue.
+	Depth int
+
+	// Peak is the highest number of elements the queue has held at once.
+	Peak int
+
+	// PushWait is the cumulative time Push operations spent blocked
+	// waiting for space in the queue.
+	PushWait time.Duration
+
+	// PopWait is the cumulative time Pop operations spent blocked
+	// waiting for an element to become available.
+	PopWait time.Duration
+}
+
+// NewStats creates a new Stats with all of its counters set to zero.
+func NewStats() *Stats {
+	return new(Stats)
+}
+
+// Snapshot returns the current statistics.
+//
+// The counters are loaded individually, so a snapshot taken while the queue is being
+// used may be slightly inconsistent. For a LockFree queue, the depth is updated after
+// the element is pushed or popped, so it is only approximate while there is contention.
+func (s *Stats) Snapshot() Snapshot {
+	if s == nil {
+		return Snapshot{}
+	}
+	depth := atomic.LoadInt64(&s.depth)
+	if depth < 0 {
+		depth = 0
+	}
+	return Snapshot{
+		Pushes:   atomic.LoadUint64(&s.pushes),
+		Pops:     atomic.LoadUint64(&s.pops),
+		Drops:    atomic.LoadUint64(&s.drops),
+		Depth:    int(depth),
+		Peak:     int(atomic.LoadInt64(&s.peak)),
+		PushWait: time.Duration(atomic.LoadInt64(&s.pushWait)),
+		PopWait:  time.Duration(atomic.LoadInt64(&s.popWait)),
+	}
+}
+
+// push records that n elements were added to the queue.
+func (s *Stats) push(n int) {
+	if s == nil || n == 0 {
+		return
+	}
+	atomic.AddUint64(&s.pushes, uint64(n))
+	depth := atomic.AddInt64(&s.depth, int64(n))
+	for {
+		peak := atomic.LoadInt64(&s.peak)
+		if depth <= peak || atomic.CompareAndSwapInt64(&s.peak, peak, depth) {
+			return
+		}
+	}
+}
+
+// pop records that n elements were removed from the queue.
+func (s *Stats) pop(n int) {
+	if s == nil || n == 0 {
+		return
+	}
+	atomic.AddUint64(&s.pops, uint64(n))
+	atomic.AddInt64(&s.depth, -int64(n))
+}
+
+// drop records that n elements were dropped before they were added to the queue.
+func (s *Stats) drop(n int) {
+	if s == nil || n == 0 {
+		return
+	}
+	atomic.AddUint64(&s.drops, uint64(n))
+}
+
+// evict records that n elements were dropped after they were added to the queue.
+func (s *Stats) evict(n int) {
+	if s == nil || n == 0 {
+		return
+	}
+	atomic.AddUint64(&s.drops, uint64(n))
+	atomic.AddInt64(&s.depth, -int64(n))
+}
+
+// now returns the current time, or the zero time if s is nil so
+// that queues without stats never have to read the clock.
+func (s *Stats) now() time.Time {
+	if s == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// pushWaited records the time a Push operation spent blocked since the given
+// time, which is ignored if it is the zero time.
+func (s *Stats) pushWaited(since time.Time) {
+	if s == nil || since.IsZero() {
+		return
+	}
+	atomic.AddInt64(&s.pushWait, int64(time.Since(since)))
+}
+
+// popWaited records the time a Pop operation spent blocked since the given
+// time, which is ignored if it is the zero time.
+func (s *Stats) popWaited(since time.Time) {
+	if s == nil || since.IsZero() {
+		return
+	}
+	atomic.AddInt64(&s.popWait, int64(time.Since(since)))
+}
@@ -0,0 +1,135 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats(t *testing.T) {
+	t.Parallel()
+
+	testPacket := func() *P {
+		return new(P)
+	}
+
+	t.Run("nil", func(t *testing.T) {
+		var stats *Stats
+		stats.push(1)
+		stats.pop(1)
+		stats.drop(1)
+		stats.evict(1)
+		stats.pushWaited(stats.now())
+		stats.popWaited(time.Now())
+		assert.Equal(t, Snapshot{}, stats.Snapshot())
+	})
+	t.Run("circular", func(t *testing.T) {
+		stats := NewStats()
+		rb := NewCircular[P, *P](3, WithStats[P, *P](stats))
+		for i := 0; i < 3; i++ {
+			require.NoError(t, rb.Push(testPacket()))
+		}
+		_, err := rb.Pop()
+		require.NoError(t, err)
+
+		snapshot := stats.Snapshot()
+		assert.Equal(t, uint64(3), snapshot.Pushes)
+		assert.Equal(t, uint64(1), snapshot.Pops)
+		assert.Equal(t, 2, snapshot.Depth)
+		assert.Equal(t, 3, snapshot.Peak)
+		assert.Zero(t, snapshot.PushWait)
+		assert.Zero(t, snapshot.PopWait)
+
+		require.NoError(t, rb.Push(testPacket()))
+		doneCh := make(chan error, 1)
+		go func() {
+			doneCh <- rb.Push(testPacket())
+		}()
+		time.Sleep(time.Millisecond * 20)
+		_, err = rb.Pop()
+		require.NoError(t, err)
+		require.NoError(t, <-doneCh)
+		assert.GreaterOrEqual(t, stats.Snapshot().PushWait, time.Millisecond*10)
+
+		dst := make([]*P, 4)
+		n, err := rb.PopBatch(dst, 4)
+		require.NoError(t, err)
+		assert.Equal(t, 3, n)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+		defer cancel()
+		_, err = rb.PopContext(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+		snapshot = stats.Snapshot()
+		assert.Equal(t, uint64(5), snapshot.Pushes)
+		assert.Equal(t, uint64(5), snapshot.Pops)
+		assert.Equal(t, 0, snapshot.Depth)
+		assert.Equal(t, 3, snapshot.Peak)
+		assert.GreaterOrEqual(t, snapshot.PopWait, time.Millisecond*10)
+	})
+	t.Run("nonblocking drops", func(t *testing.T) {
+		stats := NewStats()
+		rb := NewNonBlocking[P, *P](1, WithOverflowPolicy[P, *P](OverflowDropOldest, nil), WithStats[P, *P](stats))
+		for i := 0; i < 3; i++ {
+			require.NoError(t, rb.Push(testPacket()))
+		}
+		snapshot := stats.Snapshot()
+		assert.Equal(t, uint64(3), snapshot.Pushes)
+		assert.Equal(t, uint64(2), snapshot.Drops)
+		assert.Equal(t, 1, snapshot.Depth)
+		assert.Equal(t, 1, snapshot.Peak)
+
+		stats = NewStats()
+		rb = NewNonBlocking[P, *P](1, WithOverflowPolicy[P, *P](OverflowDropNewest, nil), WithStats[P, *P](stats))
+		n, err := rb.PushBatch([]*P{testPacket(), testPacket(), testPacket()})
+		require.NoError(t, err)
+		assert.Equal(t, 3, n)
+		snapshot = stats.Snapshot()
+		assert.Equal(t, uint64(1), snapshot.Pushes)
+		assert.Equal(t, uint64(2), snapshot.Drops)
+		assert.Equal(t, 1, snapshot.Depth)
+	})
+	t.Run("lockfree overwrite", func(t *testing.T) {
+		stats := NewStats()
+		rb := NewLockFree[P, *P](2, WithOverwrite[P, *P](nil), WithStats[P, *P](stats))
+		for i := 0; i < 5; i++ {
+			require.NoError(t, rb.Push(testPacket()))
+		}
+		assert.Len(t, rb.Drain(), 2)
+		snapshot := stats.Snapshot()
+		assert.Equal(t, uint64(5), snapshot.Pushes)
+		assert.Equal(t, uint64(2), snapshot.Pops)
+		assert.Equal(t, uint64(3), snapshot.Drops)
+		assert.Equal(t, 0, snapshot.Depth)
+		assert.Equal(t, 2, snapshot.Peak)
+	})
+	t.Run("shared", func(t *testing.T) {
+		stats := NewStats()
+		priority := NewPriority[P, *P](func(a *P, b *P) bool { return a.Int < b.Int }, 0, WithStats[P, *P](stats))
+		unbounded := NewUnbounded[P, *P](1, WithStats[P, *P](stats))
+		delay := NewDelay[P, *P](WithStats[P, *P](stats))
+		require.NoError(t, priority.Push(testPacket()))
+		require.NoError(t, unbounded.Push(testPacket()))
+		require.NoError(t, delay.PushAfter(testPacket(), time.Millisecond*20))
+		assert.Equal(t, 3, stats.Snapshot().Peak)
+
+		_, err := priority.Pop()
+		require.NoError(t, err)
+		_, err = unbounded.Pop()
+		require.NoError(t, err)
+		_, err = delay.Pop()
+		require.NoError(t, err)
+
+		snapshot := stats.Snapshot()
+		assert.Equal(t, uint64(3), snapshot.Pushes)
+		assert.Equal(t, uint64(3), snapshot.Pops)
+		assert.Equal(t, 0, snapshot.Depth)
+		assert.Greater(t, snapshot.PopWait, time.Duration(0))
+	})
+}
@@ -0,0 +1,295 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Unbounded is a FIFO queue backed by a ring buffer that starts small and
+// doubles its capacity whenever it is full, so that it never has to block
+// the caller of Push. It can optionally shrink its capacity again after a
+// sustained period of low occupancy (see WithShrinkAfter).
+//
+// It is thread safe, and Pop blocks the caller if the queue is empty.
+type Unbounded[T any, P Pointer[T]] struct {
+	_padding0   [8]uint64 //nolint:structcheck,unused
+	ring        *ring[P]
+	_padding1   [8]uint64 //nolint:structcheck,unused
+	minSize     uint64
+	_padding2   [8]uint64 //nolint:structcheck,unused
+	shrinkAfter uint64
+	_padding3   [8]uint64 //nolint:structcheck,unused
+	lowPops     uint64
+	_padding4   [8]uint64 //nolint:structcheck,unused
+	closed      bool
+	_padding5   [8]uint64 //nolint:structcheck,unused
+	lock        *sync.Mutex
+	_padding6   [8]uint64 //nolint:structcheck,unused
+	notEmpty    *sync.Cond
+	_padding7   [8]uint64 //nolint:structcheck,unused
+	graceful    bool
+	_padding8   [8]uint64 //nolint:structcheck,unused
+	stats       *Stats
+}
+
+// NewUnbounded creates a new unbounded queue whose backing ring buffer
+// initially holds the given number of elements, rounded up to the nearest power of 2.
+//
+// The capacity never shrinks below its initial value.
+func NewUnbounded[T any, P Pointer[T]](initialSize uint64, opts ...Option[T, P]) *Unbounded[T, P] {
+	o := newOptions(opts)
+	q := new(Unbounded[T, P])
+	q.graceful = o.graceful
+	q.shrinkAfter = o.shrinkAfter
+	q.stats = o.stats
+	q.lock = new(sync.Mutex)
+	q.notEmpty = sync.NewCond(q.lock)
+	q.ring = newRing[P](initialSize)
+	q.minSize = uint64(q.ring.capacity())
+	return q
+}
+
+// IsEmpty returns true if the queue is empty.
+func (q *Unbounded[T, P]) IsEmpty() (empty bool) {
+	q.lock.Lock()
+	empty = q.isEmpty()
+	q.lock.Unlock()
+	return
+}
+
+// isEmpty is an internal function used to check if the
+// queue is empty.
+func (q *Unbounded[T, P]) isEmpty() bool {
+	return q.ring.length() == 0
+}
+
+// IsFull always returns false, since the queue grows instead of becoming full.
+func (q *Unbounded[T, P]) IsFull() bool {
+	return false
+}
+
+// IsClosed returns true if the queue is Closed
+//
+// The Drain method can be used to drain the queue after it is closed.
+func (q *Unbounded[T, P]) IsClosed() (closed bool) {
+	q.lock.Lock()
+	closed = q.isClosed()
+	q.lock.Unlock()
+	return
+}
+
+// isClosed is an internal function used to check if the
+// queue is closed.
+func (q *Unbounded[T, P]) isClosed() bool {
+	return q.closed
+}
+
+// isDone is an internal function used to check if Pop operations
+// should return Closed, which is as soon as the queue is closed, or once
+// it is also empty if it was created with WithDrainOnClose.
+func (q *Unbounded[T, P]) isDone() bool {
+	return q.closed && (!q.graceful || q.isEmpty())
+}
+
+// Length returns the number of elements in the queue.
+func (q *Unbounded[T, P]) Length() (size int) {
+	q.lock.Lock()
+	size = q.ring.length()
+	q.lock.Unlock()
+	return
+}
+
+// Capacity returns the number of elements the queue can currently
+// hold before its backing ring buffer has to grow.
+func (q *Unbounded[T, P]) Capacity() (capacity int) {
+	q.lock.Lock()
+	capacity = q.ring.capacity()
+	q.lock.Unlock()
+	return
+}
+
+// Close closes the queue permanently.
+//
+// The Drain method can be used to drain the queue after it is closed.
+func (q *Unbounded[T, P]) Close() {
+	q.lock.Lock()
+	q.closed = true
+	q.notEmpty.Broadcast()
+	q.lock.Unlock()
+}
+
+// Push adds an element to the queue, growing it if it is full.
+func (q *Unbounded[T, P]) Push(p P) error {
+	q.lock.Lock()
+	if q.isClosed() {
+		q.lock.Unlock()
+		return Closed
+	}
+	q.ring.push(p)
+	q.stats.push(1)
+	q.notEmpty.Signal()
+	q.lock.Unlock()
+	return nil
+}
+
+// PushContext adds an element to the queue. Since Push never blocks,
+// the context is only checked before the element is added.
+func (q *Unbounded[T, P]) PushContext(ctx context.Context, p P) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return q.Push(p)
+}
+
+// PushBatch adds all the given elements to the queue in order in a single
+// critical section, returning the number of elements that were added.
+func (q *Unbounded[T, P]) PushBatch(ps []P) (int, error) {
+	if len(ps) == 0 {
+		return 0, nil
+	}
+	q.lock.Lock()
+	if q.isClosed() {
+		q.lock.Unlock()
+		return 0, Closed
+	}
+	for _, p := range ps {
+		q.ring.push(p)
+	}
+	q.stats.push(len(ps))
+	if len(ps) > 1 {
+		q.notEmpty.Broadcast()
+	} else {
+		q.notEmpty.Signal()
+	}
+	q.lock.Unlock()
+	return len(ps), nil
+}
+
+// Pop removes an element from the queue, blocking until one is available.
+func (q *Unbounded[T, P]) Pop() (P, error) {
+	return q.PopContext(context.Background())
+}
+
+// PopContext removes an element from the queue, blocking until one is available.
+//
+// If the context is cancelled while waiting, ctx.Err() is returned.
+func (q *Unbounded[T, P]) PopContext(ctx context.Context) (p P, err error) {
+	var stop func()
+	var waited time.Time
+	q.lock.Lock()
+LOOP:
+	if q.isDone() {
+		q.lock.Unlock()
+		q.stats.popWaited(waited)
+		if stop != nil {
+			stop()
+		}
+		return nil, Closed
+	}
+	if q.isEmpty() {
+		if err = ctx.Err(); err != nil {
+			q.lock.Unlock()
+			q.stats.popWaited(waited)
+			if stop != nil {
+				stop()
+			}
+			return nil, err
+		}
+		if stop == nil {
+			stop = wakeOnDone(ctx, q.notEmpty)
+		}
+		if waited.IsZero() {
+			waited = q.stats.now()
+		}
+		q.notEmpty.Wait()
+		goto LOOP
+	}
+
+	p = q.ring.pop()
+	q.shrink()
+	q.stats.pop(1)
+	q.lock.Unlock()
+	q.stats.popWaited(waited)
+	if stop != nil {
+		stop()
+	}
+	return
+}
+
+// PopBatch removes up to max elements (and at most len(dst)) from the queue and
+// stores them in dst, returning the number of elements that were removed.
+//
+// It blocks until at least one element is available, and then removes as many
+// elements as are available in a single critical section.
+func (q *Unbounded[T, P]) PopBatch(dst []P, max int) (n int, err error) {
+	if max > len(dst) {
+		max = len(dst)
+	}
+	if max <= 0 {
+		return 0, nil
+	}
+	var waited time.Time
+	q.lock.Lock()
+LOOP:
+	if q.isDone() {
+		q.lock.Unlock()
+		q.stats.popWaited(waited)
+		return 0, Closed
+	}
+	if q.isEmpty() {
+		if waited.IsZero() {
+			waited = q.stats.now()
+		}
+		q.notEmpty.Wait()
+		goto LOOP
+	}
+
+	for ; n < max && !q.isEmpty(); n++ {
+		dst[n] = q.ring.pop()
+		q.shrink()
+	}
+	q.stats.pop(n)
+	q.lock.Unlock()
+	q.stats.popWaited(waited)
+	return
+}
+
+// Drain removes all elements from the queue
+// and returns them in a slice.
+//
+// This function should only be called after the queue is closed.
+func (q *Unbounded[T, P]) Drain() (values []P) {
+	q.lock.Lock()
+	if q.isEmpty() {
+		q.lock.Unlock()
+		return nil
+	}
+	values = make([]P, 0, q.ring.length())
+	for !q.isEmpty() {
+		values = append(values, q.ring.pop())
+	}
+	q.stats.pop(len(values))
+	q.lock.Unlock()
+	return values
+}
+
+// shrink is an internal function used to halve the capacity of the queue once
+// it has been at most a quarter full for shrinkAfter consecutive Pop operations.
+func (q *Unbounded[T, P]) shrink() {
+	if q.shrinkAfter == 0 {
+		return
+	}
+	capacity := uint64(q.ring.capacity())
+	if capacity <= q.minSize || uint64(q.ring.length()) > capacity/4 {
+		q.lowPops = 0
+		return
+	}
+	q.lowPops++
+	if q.lowPops >= q.shrinkAfter {
+		q.ring.resize(capacity / 2)
+		q.lowPops = 0
+	}
+}
@@ -0,0 +1,110 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnbounded(t *testing.T) {
+	t.Parallel()
+
+	t.Run("grows", func(t *testing.T) {
+		q := NewUnbounded[P, *P](2)
+		assert.Equal(t, 2, q.Capacity())
+		for i := 0; i < 9; i++ {
+			require.NoError(t, q.Push(numbered(i)))
+		}
+		assert.Equal(t, 9, q.Length())
+		assert.Equal(t, 16, q.Capacity())
+		assert.False(t, q.IsFull())
+		for i := 0; i < 9; i++ {
+			p, err := q.Pop()
+			require.NoError(t, err)
+			assert.Equal(t, i, p.Int)
+		}
+	})
+	t.Run("grows while wrapped", func(t *testing.T) {
+		q := NewUnbounded[P, *P](4)
+		next, expected := 0, 0
+		for round := 0; round < 16; round++ {
+			for i := 0; i < 3; i++ {
+				require.NoError(t, q.Push(numbered(next)))
+				next++
+			}
+			for i := 0; i < 2; i++ {
+				p, err := q.Pop()
+				require.NoError(t, err)
+				require.Equal(t, expected, p.Int)
+				expected++
+			}
+		}
+		assert.Equal(t, next-expected, q.Length())
+		for _, p := range q.Drain() {
+			require.Equal(t, expected, p.Int)
+			expected++
+		}
+		assert.Equal(t, next, expected)
+	})
+	t.Run("shrinks", func(t *testing.T) {
+		q := NewUnbounded[P, *P](2, WithShrinkAfter[P, *P](1))
+		for i := 0; i < 32; i++ {
+			require.NoError(t, q.Push(numbered(i)))
+		}
+		assert.Equal(t, 32, q.Capacity())
+		for i := 0; i < 32; i++ {
+			p, err := q.Pop()
+			require.NoError(t, err)
+			require.Equal(t, i, p.Int)
+		}
+		assert.Equal(t, 2, q.Capacity())
+	})
+	t.Run("shrinks after sustained low occupancy", func(t *testing.T) {
+		q := NewUnbounded[P, *P](2, WithShrinkAfter[P, *P](4))
+		for i := 0; i < 16; i++ {
+			require.NoError(t, q.Push(numbered(i)))
+		}
+		for i := 0; i < 13; i++ {
+			_, err := q.Pop()
+			require.NoError(t, err)
+		}
+		assert.Equal(t, 16, q.Capacity())
+		for i := 0; i < 3; i++ {
+			require.NoError(t, q.Push(numbered(i)))
+			_, err := q.Pop()
+			require.NoError(t, err)
+		}
+		assert.Equal(t, 8, q.Capacity())
+		assert.Equal(t, 3, q.Length())
+	})
+	t.Run("does not shrink by default", func(t *testing.T) {
+		q := NewUnbounded[P, *P](2)
+		for i := 0; i < 32; i++ {
+			require.NoError(t, q.Push(numbered(i)))
+		}
+		for i := 0; i < 32; i++ {
+			_, err := q.Pop()
+			require.NoError(t, err)
+		}
+		assert.Equal(t, 32, q.Capacity())
+	})
+	t.Run("batch", func(t *testing.T) {
+		q := NewUnbounded[P, *P](1, WithShrinkAfter[P, *P](1))
+		packets := make([]*P, 10)
+		for i := range packets {
+			packets[i] = numbered(i)
+		}
+		n, err := q.PushBatch(packets)
+		require.NoError(t, err)
+		assert.Equal(t, 10, n)
+
+		dst := make([]*P, 10)
+		n, err = q.PopBatch(dst, len(dst))
+		require.NoError(t, err)
+		assert.Equal(t, 10, n)
+		assert.Equal(t, packets, dst)
+	})
+}
@@ -0,0 +1,192 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WaitStrategy determines how a queue operation waits when it cannot make
+// progress, for example when popping from an empty queue or pushing into a full one.
+//
+// An operation first calls Epoch, then checks whether it can make progress, and
+// if it cannot, calls Wait with the epoch it got. Whenever the state of the queue
+// changes, Notify is called, which allows strategies that park the caller to wake it
+// up without ever missing a change that happened after the epoch was taken.
+type WaitStrategy interface {
+	// Epoch returns the current epoch of the WaitStrategy.
+	Epoch() uint64
+
+	// Wait is called when an operation cannot make progress. The iteration is the
+	// number of times Wait was already called by the current operation, and the epoch
+	// is the value that Epoch returned before the operation last checked the queue.
+	Wait(iteration uint64, epoch uint64)
+
+	// Notify is called whenever the state of the queue changes.
+	Notify()
+}
+
+// BusySpin is a WaitStrategy that retries immediately, keeping the latency
+// as low as possible at the cost of a full core per waiting operation.
+type BusySpin struct{}
+
+// NewBusySpin creates a new BusySpin WaitStrategy.
+func NewBusySpin() *BusySpin {
+	return new(BusySpin)
+}
+
+// Epoch always returns 0, since BusySpin never parks the caller.
+func (*BusySpin) Epoch() uint64 { return 0 }
+
+// Wait returns immediately.
+func (*BusySpin) Wait(uint64, uint64) {}
+
+// Notify does nothing.
+func (*BusySpin) Notify() {}
+
+// Yield is a WaitStrategy that yields the processor to other goroutines
+// before retrying. This is the default WaitStrategy.
+type Yield struct{}
+
+// NewYield creates a new Yield WaitStrategy.
+func NewYield() *Yield {
+	return new(Yield)
+}
+
+// Epoch always returns 0, since Yield never parks the caller.
+func (*Yield) Epoch() uint64 { return 0 }
+
+// Wait yields the processor.
+func (*Yield) Wait(uint64, uint64) {
+	runtime.Gosched()
+}
+
+// Notify does nothing.
+func (*Yield) Notify() {}
+
+// Sleep is a WaitStrategy that sleeps before retrying, doubling the sleep duration
+// on every iteration starting from min, up to max.
+type Sleep struct {
+	min time.Duration
+	max time.Duration
+}
+
+// NewSleep creates a new Sleep WaitStrategy with the given minimum and maximum
+// sleep durations.
+func NewSleep(min time.Duration, max time.Duration) *Sleep {
+	if min <= 0 {
+		min = time.Microsecond
+	}
+	if max < min {
+		max = min
+	}
+	return &Sleep{
+		min: min,
+		max: max,
+	}
+}
+
+// Epoch always returns 0, since Sleep never parks the caller.
+func (*Sleep) Epoch() uint64 { return 0 }
+
+// Wait sleeps for min << iteration, capped at max.
+func (s *Sleep) Wait(iteration uint64, _ uint64) {
+	d := s.min
+	for i := uint64(0); i < iteration && d < s.max; i++ {
+		d <<= 1
+	}
+	if d > s.max {
+		d = s.max
+	}
+	time.Sleep(d)
+}
+
+// Notify does nothing.
+func (*Sleep) Notify() {}
+
+// Park is a WaitStrategy that yields the processor for a number of iterations
+// and then parks the caller until the queue is notified of a change, so that
+// idle operations do not consume any CPU.
+type Park struct {
+	_padding0 [8]uint64 //nolint:structcheck,unused
+	epoch     uint64
+	_padding1 [8]uint64 //nolint:structcheck,unused
+	waiters   uint64
+	_padding2 [8]uint64 //nolint:structcheck,unused
+	spins     uint64
+	_padding3 [8]uint64 //nolint:structcheck,unused
+	lock      *sync.Mutex
+	_padding4 [8]uint64 //nolint:structcheck,unused
+	cond      *sync.Cond
+}
+
+// NewPark creates a new Park WaitStrategy that yields the processor for
+// the given number of iterations before parking the caller.
+func NewPark(spins uint64) *Park {
+	p := new(Park)
+	p.spins = spins
+	p.lock = new(sync.Mutex)
+	p.cond = sync.NewCond(p.lock)
+	return p
+}
+
+// Epoch returns the number of times Notify has been called.
+func (p *Park) Epoch() uint64 {
+	return atomic.LoadUint64(&p.epoch)
+}
+
+// Wait yields the processor if the iteration is below the configured number
+// of spins, and otherwise parks the caller until the epoch changes.
+//
+// The number of waiters is incremented before the epoch is checked, and Notify
+// increments the epoch before checking the number of waiters, so at least one
+// of them always observes the other and no notification is ever missed.
+func (p *Park) Wait(iteration uint64, epoch uint64) {
+	if iteration < p.spins {
+		runtime.Gosched()
+		return
+	}
+	p.lock.Lock()
+	atomic.AddUint64(&p.waiters, 1)
+	for atomic.LoadUint64(&p.epoch) == epoch {
+		p.cond.Wait()
+	}
+	atomic.AddUint64(&p.waiters, ^uint64(0))
+	p.lock.Unlock()
+}
+
+// Notify increments the epoch and wakes up all parked callers.
+func (p *Park) Notify() {
+	atomic.AddUint64(&p.epoch, 1)
+	if atomic.LoadUint64(&p.waiters) > 0 {
+		p.lock.Lock()
+		p.cond.Broadcast()
+		p.lock.Unlock()
+	}
+}
+
+// notifyOnDone starts a goroutine that notifies the given WaitStrategy once the context
+// is done, so that parked operations can observe the cancellation.
+//
+// The returned function must be called to stop the goroutine. It is nil
+// if the context can never be cancelled.
+func notifyOnDone(ctx context.Context, wait WaitStrategy) func() {
+	if ctx.Done() == nil {
+		return nil
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			wait.Notify()
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+	}
+}
@@ -0,0 +1,122 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWaitStrategy(t *testing.T) {
+	t.Parallel()
+
+	t.Run("sleep backoff", func(t *testing.T) {
+		s := NewSleep(time.Millisecond, time.Millisecond*4)
+		start := time.Now()
+		s.Wait(0, s.Epoch())
+		assert.GreaterOrEqual(t, time.Since(start), time.Millisecond)
+
+		start = time.Now()
+		s.Wait(62, s.Epoch())
+		elapsed := time.Since(start)
+		assert.GreaterOrEqual(t, elapsed, time.Millisecond*4)
+	})
+	t.Run("park spins before parking", func(t *testing.T) {
+		p := NewPark(2)
+		epoch := p.Epoch()
+		p.Wait(0, epoch)
+		p.Wait(1, epoch)
+	})
+	t.Run("park wakes on notify", func(t *testing.T) {
+		p := NewPark(0)
+		epoch := p.Epoch()
+		done := make(chan struct{})
+		go func() {
+			p.Wait(0, epoch)
+			close(done)
+		}()
+		select {
+		case <-done:
+			t.Fatal("Park did not park the caller")
+		case <-time.After(time.Millisecond * 10):
+		}
+		p.Notify()
+		select {
+		case <-done:
+		case <-time.After(time.Millisecond * 100):
+			t.Fatal("Park did not wake up the caller on notify")
+		}
+	})
+	t.Run("park does not wait on stale epoch", func(t *testing.T) {
+		p := NewPark(0)
+		epoch := p.Epoch()
+		p.Notify()
+		p.Wait(0, epoch)
+	})
+	t.Run("lockfree", func(t *testing.T) {
+		strategies := map[string]func() WaitStrategy{
+			"busy spin": func() WaitStrategy { return NewBusySpin() },
+			"yield":     func() WaitStrategy { return NewYield() },
+			"sleep":     func() WaitStrategy { return NewSleep(time.Microsecond, time.Millisecond) },
+			"park":      func() WaitStrategy { return NewPark(16) },
+			"park only": func() WaitStrategy { return NewPark(0) },
+		}
+		for name, strategy := range strategies {
+			name, strategy := name, strategy
+			t.Run(name, func(t *testing.T) {
+				if name == "busy spin" && runtime.GOMAXPROCS(0) < 2 {
+					t.Skip("busy spinning requires more than one processor")
+				}
+				const producers = 4
+				const consumers = 4
+				const items = 512
+				rb := NewLockFree[P, *P](4, WithWaitStrategy[P, *P](strategy()))
+
+				var wg sync.WaitGroup
+				for i := 0; i < producers; i++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						for j := 0; j < items; j++ {
+							assert.NoError(t, rb.Push(&P{Int: j}))
+						}
+					}()
+				}
+				results := make(chan int, consumers)
+				for i := 0; i < consumers; i++ {
+					go func() {
+						received := 0
+						for {
+							if _, err := rb.Pop(); err != nil {
+								results <- received
+								return
+							}
+							received++
+						}
+					}()
+				}
+				wg.Wait()
+				for rb.Length() > 0 {
+					time.Sleep(time.Millisecond)
+				}
+				rb.Close()
+
+				total := 0
+				for i := 0; i < consumers; i++ {
+					select {
+					case received := <-results:
+						total += received
+					case <-time.After(time.Second):
+						t.Fatal("LockFree did not unblock consumers on close")
+					}
+				}
+				require.Equal(t, producers*items, total)
+			})
+		}
+	})
+}